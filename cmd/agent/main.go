@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"log"
+	"study_langchain/pkg/mllm"
+	"time"
+)
+
+type timeArgs struct {
+	Timezone string `json:"timezone,omitempty" describe:"IANA 时区，例：Asia/Shanghai，为空时使用本地时区"`
+}
+
+func main() {
+	var (
+		configFile = flag.String("config", "", "配置文件路径(.yaml/.toml)，环境变量 MLLM_CONFIG")
+		k          = flag.Int("k", 4, "知识库检索工具每次检索的分块数量，为0时不注册")
+		maxSteps   = flag.Int("max-steps", 8, "最多调用模型的次数")
+	)
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("用法：agent [参数] 问题")
+	}
+
+	cfg, err := mllm.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("读取配置失败：%s", err.Error())
+	}
+	if *k < 1 {
+		cfg.Mongo.URI = "" // 不需要连接向量库
+	}
+
+	ctx := context.Background()
+	client, err := mllm.NewClientFromConfig(ctx, cfg)
+	if err != nil {
+		log.Fatalf("初始化失败(%s)：%s", cfg, err.Error())
+	}
+	defer client.Close(ctx)
+
+	now := mllm.NewTool("current_time", "获取当前时间", func(_ context.Context, args timeArgs) (string, error) {
+		if args.Timezone == "" {
+			return time.Now().Format(time.RFC3339), nil
+		}
+		loc, err := time.LoadLocation(args.Timezone)
+		if err != nil {
+			return "", err
+		}
+		return time.Now().In(loc).Format(time.RFC3339), nil
+	})
+
+	agent := client.NewAgent(mllm.WithTools(now), mllm.WithSearchTool(*k), mllm.WithMaxSteps(*maxSteps))
+	res, err := agent.Run(ctx, []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, flag.Arg(0))})
+	if err != nil {
+		log.Fatalf("执行失败：%s", err.Error())
+	}
+
+	for i, step := range res.Steps {
+		fmt.Printf("[%d] %s(%s) => %.200s %v\n", i+1, step.Name, step.Arguments, step.Result, step.Err)
+	}
+	fmt.Println(res.Content)
+}
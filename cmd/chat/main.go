@@ -2,63 +2,263 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/peterh/liner"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/schema"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"study_langchain/pkg/mllm"
+	"time"
 )
 
-// 单次通话
-//func main() {
-//	llm, err := ollama.New(ollama.WithModel("qwen2.5:3b"), ollama.WithServerURL("http://127.0.0.1:11434"))
-//	if err != nil {
-//		fmt.Printf("连接ollama错误:%s\n", err.Error())
-//		return
-//	}
-//
-//	res, err := llms.GenerateFromSinglePrompt(context.Background(), llm, "你是谁")
-//	if err != nil {
-//		fmt.Printf("请求失败：%s\n", err.Error())
-//		return
-//	}
-//	fmt.Printf("返回信息%s\n", res)
-//}
+const help = `命令：
+  /reset            清空对话历史
+  /system [提示词]  查看或设置系统提示词
+  /rag on|off       开启或关闭知识库检索
+  /sources          查看上一次回答引用的分块
+  /model [名称]     查看或切换模型
+  /help             查看帮助
+  /exit             退出`
+
+// repl 交互式对话
+type repl struct {
+	client    *mllm.Client
+	cfg       *mllm.Config
+	connected bool     // 是否已通过配置初始化向量库
+	kbs       []string // 检索的知识库，为空时使用 mongo.collection
+	model     string
+	system    string
+	rag       bool
+	k         int
+	history   []llms.MessageContent
+	sources   []schema.Document // 上一次回答引用的分块
+	record    *os.File          // 对话记录
+}
 
 func main() {
-	llm, err := ollama.New(ollama.WithModel("qwen2.5:3b"), ollama.WithServerURL("http://127.0.0.1:11434"))
+	var (
+		configFile    = flag.String("config", "", "配置文件路径(.yaml/.toml)，环境变量 MLLM_CONFIG")
+		system        = flag.String("system", "你是一个乐于助人的助手，请使用中文回答问题。", "系统提示词")
+		rag           = flag.Bool("rag", false, "启动时开启知识库检索")
+		k             = flag.Int("k", 4, "知识库检索的分块数量")
+		kbs           = flag.String("kb", "", "知识库名称，多个以逗号分隔时同时检索，为空时使用 mongo.collection")
+		transcriptDir = flag.String("transcript-dir", "./transcripts", "对话记录保存目录，为空时不保存")
+	)
+	flag.Parse()
+
+	cfg, err := mllm.LoadConfig(*configFile)
 	if err != nil {
-		fmt.Printf("连接ollama错误:%s\n", err.Error())
-		return
+		log.Fatalf("读取配置失败：%s", err.Error())
 	}
-
-	msg := []llms.MessageContent{
-		{Role: llms.ChatMessageTypeSystem, Parts: []llms.ContentPart{llms.TextContent{Text: "你是一个熟读三国演义的专家，请尽可能的帮我回答与三国相关的问题。"}}},
-		llms.TextParts(llms.ChatMessageTypeHuman, "请问刘备是谁"),
+	if err = cfg.Validate(); err != nil {
+		log.Fatalf("配置错误：%s", err.Error())
 	}
-	res, err := llm.GenerateContent(context.Background(), msg)
+
+	// 开启知识库检索时才连接 mongodb
+	client, err := mllm.NewLLM(cfg.Model, cfg.OllamaURL)
 	if err != nil {
-		fmt.Printf("请求失败：%s\n", err.Error())
-		return
+		log.Fatalf("llm初始化失败：%s", err.Error())
 	}
-	choices := res.Choices
-	if len(choices) < 1 {
-		fmt.Printf("模型返回空消息")
-		return
+
+	r := &repl{client: client, cfg: cfg, model: cfg.Model, system: *system, k: *k}
+	defer func() { r.client.Close(context.Background()) }()
+	if *kbs != "" {
+		r.kbs = strings.Split(*kbs, ",")
+	}
+	if *transcriptDir != "" {
+		if r.record, err = newTranscript(*transcriptDir); err != nil {
+			log.Fatalf("创建对话记录失败：%s", err.Error())
+		}
+		defer r.record.Close()
 	}
+	if *rag {
+		if err = r.setRAG(true); err != nil {
+			log.Fatalf("开启知识库检索失败：%s", err.Error())
+		}
+	}
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(complete)
+
+	// 输入历史保存在用户目录下
+	home, _ := os.UserHomeDir()
+	historyFile := filepath.Join(home, ".mllm_chat_history")
+	if f, err := os.Open(historyFile); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	defer func() {
+		if f, err := os.Create(historyFile); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	fmt.Printf("模型：%s，知识库检索：%v，输入 /help 查看命令\n", r.model, r.rag)
+	for {
+		input, err := line.Prompt("> ")
+		if errors.Is(err, liner.ErrPromptAborted) {
+			continue
+		}
+		if err != nil {
+			fmt.Println()
+			return
+		}
 
-	fmt.Printf("返回信息%s\n", choices[0].Content)
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+		line.AppendHistory(input)
 
-	// 携带历史数据进行访问
-	msg = append(msg, llms.TextParts(llms.ChatMessageTypeAI, choices[0].Content), llms.TextParts(llms.ChatMessageTypeHuman, "曹操呢"))
-	res, err = llm.GenerateContent(context.Background(), msg)
+		if strings.HasPrefix(input, "/") {
+			if quit := r.command(input); quit {
+				return
+			}
+			continue
+		}
+		if err = r.ask(input); err != nil {
+			fmt.Printf("\n请求失败：%s\n", err.Error())
+		}
+	}
+}
+
+// command 执行斜杠命令，返回 true 时退出
+func (r *repl) command(input string) bool {
+	name, arg, _ := strings.Cut(input, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		fmt.Println(help)
+	case "/reset":
+		r.history, r.sources = nil, nil
+		fmt.Println("对话历史已清空")
+	case "/system":
+		if arg != "" {
+			r.system = arg
+			r.writeRecord("system", arg)
+		}
+		fmt.Printf("系统提示词：%s\n", r.system)
+	case "/rag":
+		if arg != "on" && arg != "off" {
+			fmt.Printf("知识库检索：%v，使用 /rag on|off 切换\n", r.rag)
+			break
+		}
+		if err := r.setRAG(arg == "on"); err != nil {
+			fmt.Printf("开启知识库检索失败：%s\n", err.Error())
+			break
+		}
+		fmt.Printf("知识库检索：%v\n", r.rag)
+	case "/sources":
+		if len(r.sources) < 1 {
+			fmt.Println("上一次回答没有引用知识库")
+		}
+		for i, doc := range r.sources {
+			fmt.Printf("[%d] %v#%v score=%.4f\n%s\n\n", i+1, doc.Metadata[mllm.FilenameKey], doc.Metadata[mllm.ChunkIndexKey], doc.Score, doc.PageContent)
+		}
+	case "/model":
+		if arg != "" {
+			r.model = arg
+		}
+		fmt.Printf("模型：%s\n", r.model)
+	default:
+		fmt.Printf("未知命令：%s\n%s\n", name, help)
+	}
+	return false
+}
+
+// setRAG 开启或关闭知识库检索，第一次开启时根据配置创建 Client，使用配置中的向量模型、缓存、知识库和租户
+func (r *repl) setRAG(on bool) error {
+	if on && !r.connected {
+		if r.cfg.Mongo.URI == "" {
+			return errors.New("未配置 mongodb")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		client, err := mllm.NewClientFromConfig(ctx, r.cfg)
+		if err != nil {
+			return err
+		}
+		r.client.Close(ctx)
+		r.client, r.connected = client, true
+	}
+	r.rag = on
+	return nil
+}
+
+// ask 发送消息并流式输出回答
+func (r *repl) ask(input string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	messages := make([]llms.MessageContent, 0, len(r.history)+2)
+	messages = append(messages, llms.TextParts(llms.ChatMessageTypeSystem, r.system))
+	messages = append(messages, r.history...)
+	messages = append(messages, llms.TextParts(llms.ChatMessageTypeHuman, input))
+
+	k := 0
+	if r.rag {
+		k = r.k
+		if r.cfg.Tenant != "" {
+			ctx = mllm.WithTenant(ctx, r.cfg.Tenant)
+		}
+		if len(r.kbs) > 0 {
+			ctx = mllm.WithKnowledgeBases(ctx, r.kbs...)
+		}
+	}
+	res, err := r.client.Chat(ctx, messages, k, llms.WithModel(r.model), llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+		fmt.Print(string(chunk))
+		return nil
+	}))
+	fmt.Println()
 	if err != nil {
-		fmt.Printf("请求失败：%s\n", err.Error())
-		return
+		return err
 	}
-	choices = res.Choices
-	if len(choices) < 1 {
-		fmt.Printf("模型返回空消息")
+
+	r.sources = res.Sources
+	r.history = append(r.history, llms.TextParts(llms.ChatMessageTypeHuman, input), llms.TextParts(llms.ChatMessageTypeAI, res.Content))
+	r.writeRecord("user", input)
+	r.writeRecord("assistant", res.Content)
+	return nil
+}
+
+// newTranscript 创建对话记录文件
+func newTranscript(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(dir, "chat-"+time.Now().Format("20060102-150405")+".md")
+	return os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+func (r *repl) writeRecord(role, content string) {
+	if r.record == nil {
 		return
 	}
+	fmt.Fprintf(r.record, "## %s (%s)\n\n%s\n\n", role, time.Now().Format(time.DateTime), content)
+}
 
-	fmt.Printf("返回信息%s\n", choices[0].Content)
+func complete(line string) []string {
+	if !strings.HasPrefix(line, "/") {
+		return nil
+	}
+	res := make([]string, 0)
+	for _, c := range []string{"/reset", "/system ", "/rag on", "/rag off", "/sources", "/model ", "/help", "/exit"} {
+		if strings.HasPrefix(c, line) {
+			res = append(res, c)
+		}
+	}
+	return res
 }
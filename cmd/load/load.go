@@ -28,7 +28,7 @@ func main() {
 	}
 
 	for _, doc := range docs {
-		fmt.Printf("%s\n", doc)
+		fmt.Printf("%v\n", doc)
 	}
 }
 
@@ -1,43 +1,41 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
-	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/prompts"
 	"log"
 	"study_langchain/pkg/mllm"
 )
 
 func main() {
-	client, err := mllm.NewLLM("qwen2.5:3b", "http://127.0.0.1:11434")
+	cfg, err := mllm.LoadConfig("") // 环境变量 MLLM_CONFIG 指定配置文件，未指定时使用默认值
 	if err != nil {
-		log.Fatalf("llm初始化失败：%s", err.Error())
+		log.Fatalf("读取配置失败：%s", err.Error())
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatalf("配置错误：%s", err.Error())
 	}
 
-	// 使用模板进行对话
-	template := prompts.NewChatPromptTemplate([]prompts.MessageFormatter{
-		prompts.NewSystemMessagePromptTemplate("你是一个翻译人员，只翻译文本，不进行解释", nil),
-		prompts.NewHumanMessagePromptTemplate("将此文本从{{.inputLang}}转换为{{.outputLang}}:\n{{.input}}", []string{"inputLang", "outputLang", "input"}),
-	})
-
-	value, err := template.FormatPrompt(map[string]any{
-		"inputLang":  "English",
-		"outputLang": "Chinese",
-		"input":      "I love programming",
-	})
+	// 只使用 llm，不需要连接向量库
+	client, err := mllm.NewLLM(cfg.Model, cfg.OllamaURL)
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatalf("llm初始化失败：%s", err.Error())
 	}
-
-	msg := make([]llms.MessageContent, 0, len(value.Messages()))
-	for _, v := range value.Messages() {
-		msg = append(msg, llms.MessageContent{Role: v.GetType(), Parts: []llms.ContentPart{llms.TextPart(v.GetContent())}})
+	r, err := mllm.LoadPrompts(cmp.Or(cfg.Prompts, "prompts"))
+	if err != nil {
+		log.Fatalf("加载提示词模板失败：%s", err.Error())
 	}
+	client.SetPrompts(r)
 
-	res, err := client.LLM.GenerateContent(context.Background(), msg)
+	// 使用模板库中的 prompts/translate.yaml 进行对话
+	res, err := client.ChatWithPrompt(context.Background(), "translate@1", map[string]any{
+		"inputLang":  "English",
+		"outputLang": "Chinese",
+		"input":      "I love programming",
+	}, 0)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	fmt.Println(res.Choices[0].Content)
+	fmt.Println(res.Content)
 }
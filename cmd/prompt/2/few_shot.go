@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/tmc/langchaingo/outputparser"
-	"github.com/tmc/langchaingo/prompts"
 	"log"
 	"study_langchain/pkg/mllm"
 )
@@ -14,41 +12,46 @@ type Trans struct {
 }
 
 func main() {
-	examplePrompt := prompts.NewPromptTemplate("例：\n将此文本从{{.inputLang}}转换为{{.outputLang}}:\n{{.input}}\n```json\n {\"text\":\"{{.output}}\"} \n```", []string{"inputLang", "outputLang", "input", "output"})
-	examples := []map[string]string{{"inputLang": "English", "outputLang": "Chinese", "input": "I love programming", "output": "我爱编程"}}
-
-	p, err := prompts.NewFewShotPrompt(examplePrompt, examples, nil,
-		"你是一个翻译人员，只翻译文本，不对文本进行解释。", "请开始你的回答: 将此文本从{{.inputLang}}转换为{{.outputLang}}: {{.question}}",
-		[]string{"question", "inputLang", "outputLang"}, map[string]interface{}{"type": func() string { return "json" }},
-		"\n", prompts.TemplateFormatGoTemplate, true)
+	cfg, err := mllm.LoadConfig("") // 环境变量 MLLM_CONFIG 指定配置文件，未指定时使用默认值
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("读取配置失败：%s", err.Error())
+	}
+	if cfg.Prompts == "" {
+		cfg.Prompts = "prompts"
 	}
 
-	msg, err := p.Format(map[string]any{"inputLang": "English", "outputLang": "Chinese", "question": "What a nice day today"})
+	client, err := mllm.NewClientFromConfig(context.Background(), cfg)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("llm初始化失败：%s", err.Error())
 	}
-	fmt.Println(msg)
+	defer client.Close(context.Background())
 
-	client, err := mllm.NewLLM("qwen2.5:3b", "http://127.0.0.1:11434")
+	// 少样本示例在 prompts/translate_json.yaml 中定义
+	p, err := client.Prompt("translate@2")
 	if err != nil {
-		log.Fatalf("llm初始化失败：%s", err.Error())
+		log.Fatal(err)
 	}
 
-	res, err := client.LLM.Call(context.Background(), msg)
+	// 示例保存到向量库，根据问题选择最相似的2个示例，替代固定的示例
+	selector, err := client.NewExampleSelector(context.Background(), "few_shot_examples", p.FewShot.Examples,
+		mllm.WithExampleK(2), mllm.WithExampleMaxTokens(200),
+		mllm.WithExampleInputKeys("question"), mllm.WithExampleKeys("input"))
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatal(err)
 	}
 
-	// 对返回结果进行解析
-	output, err := outputparser.NewDefined(Trans{})
+	// 选择器只作用于本次调用，不修改模板库中共享的模板
+	msgs, err := p.Messages(context.Background(), map[string]any{"inputLang": "English", "outputLang": "Chinese", "question": "What a nice day today"},
+		mllm.WithPromptSelector(selector))
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Fatal(err)
 	}
-	trans, err := output.Parse(res)
+	fmt.Println(msgs)
+
+	// 按 Trans 的 JSON Schema 输出，格式错误时最多重试2次
+	trans, err := mllm.Structured[Trans](context.Background(), client, msgs, 2)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	fmt.Println(trans)
+	fmt.Println(trans.Text)
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"study_langchain/pkg/mllm"
+	"text/tabwriter"
+	"time"
+)
+
+// supportedExts 支持加载的文件类型，与 mllm 中的加载器保持一致
+var supportedExts = map[string]bool{".md": true, ".txt": true, ".pdf": true}
+
+func runIngest(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	if len(args) < 1 {
+		return errors.New("请指定要加载的文件或目录")
+	}
+
+	total := 0
+	for _, path := range args {
+		ids, err := client.AddDocuments(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s：%w", path, err)
+		}
+		fmt.Printf("%s：新增 %d 个分块\n", path, len(ids))
+		total += len(ids)
+	}
+	fmt.Printf("共新增 %d 个分块\n", total)
+	return nil
+}
+
+func runSync(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	fset := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := fset.Bool("dry-run", false, "只输出需要处理的文件，不进行修改")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return errors.New("请指定要同步的目录")
+	}
+	root := filepath.Clean(fset.Arg(0))
+
+	// 本地文件及修改时间，路径统一为 filepath.Clean 后的格式
+	local := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !supportedExts[filepath.Ext(path)] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		local[filepath.Clean(path)] = info.ModTime().Format(time.DateTime)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sources, err := client.ListSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 修改过的文件重新加载，AddDocuments 写入成功后删除旧分块；已不存在的文件直接删除
+	stale := make([]string, 0)
+	stored := make(map[string]string) // Clean 后的路径 -> 向量库中的文件名
+	for _, s := range sources {
+		filename := filepath.Clean(s.Filename)
+		stored[filename] = s.Filename
+		updated, ok := local[filename]
+		switch {
+		case ok && updated != s.UpdatedTime:
+			fmt.Printf("修改：%s\n", s.Filename)
+		case !ok && inRoot(root, filename):
+			fmt.Printf("删除：%s\n", s.Filename)
+			stale = append(stale, s.Filename)
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if len(stale) > 0 {
+		n, err := client.DeleteBySource(ctx, stale...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("删除 %d 个分块\n", n)
+	}
+
+	for path := range local {
+		// 使用向量库中的文件名，AddDocuments 按文件名去重和删除旧分块
+		if name, ok := stored[path]; ok {
+			path = name
+		}
+		ids, err := client.AddDocuments(ctx, path)
+		if err != nil {
+			return fmt.Errorf("%s：%w", path, err)
+		}
+		if len(ids) > 0 {
+			fmt.Printf("%s：新增 %d 个分块\n", path, len(ids))
+		}
+	}
+	return nil
+}
+
+// inRoot filename 是否为 root 或 root 下的文件，两者都是 filepath.Clean 后的路径，root 为 . 时匹配所有相对路径
+func inRoot(root, filename string) bool {
+	rel, err := filepath.Rel(root, filename)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func runQuery(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	fset := flag.NewFlagSet("query", flag.ContinueOnError)
+	k := fset.Int("k", 4, "返回的分块数量")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() < 1 {
+		return errors.New("请输入查询内容")
+	}
+
+	docs, err := client.Search(ctx, strings.Join(fset.Args(), " "), *k)
+	if err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		fmt.Printf("[%d] score=%.4f %v#%v\n%s\n\n", i+1, doc.Score, doc.Metadata[mllm.FilenameKey], doc.Metadata[mllm.ChunkIndexKey], doc.PageContent)
+	}
+	return nil
+}
+
+func runAsk(ctx context.Context, cfg *mllm.Config, client *mllm.Client, args []string) error {
+	fset := flag.NewFlagSet("ask", flag.ContinueOnError)
+	route := fset.Bool("route", false, "根据 knowledge_bases 中的 description 选择要检索的知识库")
+	threshold := fset.Float64("threshold", 0, "大于0时使用向量相似度选择知识库，不调用模型")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() < 1 {
+		return errors.New("请输入问题")
+	}
+	question := strings.Join(fset.Args(), " ")
+
+	if !*route {
+		res, err := client.Chain(ctx, question)
+		if err != nil {
+			return err
+		}
+		fmt.Println(res["text"])
+		return nil
+	}
+
+	var opts []mllm.RouterOption
+	if *threshold > 0 {
+		opts = append(opts, mllm.WithRouterEmbedding(float32(*threshold)))
+	}
+	router, err := client.NewRouter(cfg.Routes(), opts...)
+	if err != nil {
+		return err
+	}
+	res, err := router.Chain(ctx, question)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("知识库：%s\n%s\n", strings.Join(res["knowledge_bases"].([]string), ","), res["text"])
+	return nil
+}
+
+func runList(ctx context.Context, _ *mllm.Config, client *mllm.Client, _ []string) error {
+	sources, err := client.ListSources(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "文件\t分块数\t修改时间")
+	for _, s := range sources {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", s.Filename, s.Chunks, s.UpdatedTime)
+	}
+	return w.Flush()
+}
+
+func runDelete(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	if len(args) < 1 {
+		return errors.New("请指定要删除的文件")
+	}
+
+	n, err := client.DeleteBySource(ctx, args...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("删除 %d 个分块\n", n)
+	return nil
+}
+
+func runPurge(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("请指定要删除的租户")
+	}
+
+	n, err := client.DeleteTenant(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("删除租户 %s 的 %d 个分块\n", args[0], n)
+	return nil
+}
+
+func runStats(ctx context.Context, _ *mllm.Config, client *mllm.Client, _ []string) error {
+	stats, err := client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("文件数：%d\n分块数：%d\n最后修改：%s\n", stats.Sources, stats.Chunks, stats.UpdatedTime)
+	return nil
+}
+
+// newIndexWait 根据命令参数生成索引等待配置
+func newIndexWait(fset *flag.FlagSet) *mllm.IndexWait {
+	wait := mllm.DefaultIndexWait
+	fset.DurationVar(&wait.Timeout, "timeout", wait.Timeout, "等待索引的超时时间")
+	fset.Var((*positiveDuration)(&wait.Interval), "interval", "查询索引状态的初始间隔，`duration` 必须大于0")
+	return &wait
+}
+
+// positiveDuration 大于0的时间间隔参数
+type positiveDuration time.Duration
+
+func (d *positiveDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *positiveDuration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return errors.New("必须大于0")
+	}
+	*d = positiveDuration(v)
+	return nil
+}
+
+func runIndex(ctx context.Context, cfg *mllm.Config, _ *mllm.Client, args []string) error {
+	fset := flag.NewFlagSet("index", flag.ContinueOnError)
+	wait := newIndexWait(fset)
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	args = fset.Args()
+	if len(args) != 1 {
+		return errors.New("用法：rag index [-timeout 10m] [-interval 1s] create|update|drop|list")
+	}
+
+	m := cfg.Mongo
+	store, err := mllm.NewMongodbStore(m.URI, m.Database, m.Collection, m.Index)
+	if err != nil {
+		return err
+	}
+	defer store.Close(context.Background())
+	store.SetIndexWait(*wait)
+
+	switch args[0] {
+	case "create":
+		if ok, _ := store.SelectIndex(ctx, m.Index); ok {
+			fmt.Printf("索引 %s 已存在\n", m.Index)
+			return nil
+		}
+		if !store.SelectCollection(ctx) {
+			if err = store.CreateCollection(ctx); err != nil {
+				return err
+			}
+		}
+		if err = store.CreateIndex(ctx, m.Index, m.Fields()); err != nil {
+			return err
+		}
+		fmt.Printf("已创建索引 %s\n", m.Index)
+	case "update":
+		if err = store.UpdateIndex(ctx, m.Index, m.Fields()); err != nil {
+			return err
+		}
+		fmt.Printf("已更新索引 %s\n", m.Index)
+	case "drop":
+		if err = store.DropIndex(ctx, m.Index); err != nil {
+			return err
+		}
+		fmt.Printf("已删除索引 %s\n", m.Index)
+	case "list":
+		list, err := store.ListIndexes(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "索引\t类型\t状态\t可查询\t字段")
+		for _, idx := range list {
+			fields := make([]string, 0, len(idx.Fields))
+			for _, f := range idx.Fields {
+				if f.Type == mllm.FieldTypeVector {
+					fields = append(fields, fmt.Sprintf("%s(%s,%d,%s)", f.Path, f.Type, f.NumDimensions, f.Similarity))
+				} else {
+					fields = append(fields, fmt.Sprintf("%s(%s)", f.Path, f.Type))
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%s\n", idx.Name, idx.Type, idx.Status, idx.Queryable, strings.Join(fields, " "))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("不支持的操作 %s，可选 create|update|drop|list", args[0])
+	}
+	return nil
+}
+
+func runMigrate(ctx context.Context, cfg *mllm.Config, client *mllm.Client, args []string) error {
+	m := cfg.Mongo
+	fset := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	wait := newIndexWait(fset)
+	index := fset.String("index", m.Index, "新集合的向量索引名称")
+	dims := fset.Int("dimensions", m.Dimensions, "新索引的向量维度")
+	similarity := fset.String("similarity", string(m.Similarity), "新索引的相似度算法")
+	embModel := fset.String("embedding-model", "", "新集合使用的向量模型，为空时使用当前模型")
+	batch := fset.Int("batch", 100, "每次写入的分块数量")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return errors.New("用法：rag migrate [-index 索引] [-dimensions 维度] [-similarity 算法] [-embedding-model 模型] <新集合>")
+	}
+
+	m.Collection, m.Index, m.Dimensions, m.Similarity = fset.Arg(0), *index, *dims, mllm.FieldSimilarity(*similarity)
+	target, err := mllm.NewMongodbStore(m.URI, m.Database, m.Collection, m.Index, m.Fields()...)
+	if err != nil {
+		return err
+	}
+	target.SetIndexWait(*wait)
+
+	opts := []mllm.MigrateOption{mllm.WithMigrateBatchSize(*batch), mllm.WithMigrateProgress(func(done, total int) {
+		fmt.Printf("\r已迁移 %d/%d 个分块", done, total)
+	})}
+	if *embModel != "" {
+		opts = append(opts, mllm.WithMigrateEmbeddingModel(*embModel))
+	}
+	res, err := client.Migrate(ctx, target, opts...)
+	fmt.Println()
+	if err != nil {
+		target.Close(context.Background())
+		return err
+	}
+	defer res.Previous.Close(context.Background())
+
+	fmt.Printf("已迁移 %d 个分块到 %s(索引 %s)，请将配置中的 mongo.collection 和 mongo.index 修改为新的集合\n", res.Chunks, m.Collection, m.Index)
+	if *embModel != "" {
+		fmt.Printf("并将 embedding_model 修改为 %s\n", *embModel)
+	}
+	return nil
+}
+
+func runReEmbed(ctx context.Context, _ *mllm.Config, client *mllm.Client, args []string) error {
+	fset := flag.NewFlagSet("reembed", flag.ContinueOnError)
+	batch := fset.Int("batch", 100, "每批重新生成向量的分块数量")
+	checkpoint := fset.String("checkpoint", ".reembed.json", "进度文件，为空时不保存进度")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	model, version, err := client.EmbeddingModel(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("向量模型：%s %s\n", model, version)
+
+	opts := []mllm.ReEmbedOption{mllm.WithReEmbedBatchSize(*batch), mllm.WithReEmbedProgress(func(cp mllm.ReEmbedCheckpoint) {
+		fmt.Printf("\r已处理 %d 个分块", cp.Done)
+	})}
+	if *checkpoint != "" {
+		opts = append(opts, mllm.WithReEmbedCheckpoint(*checkpoint))
+	}
+	cp, err := client.ReEmbed(ctx, opts...)
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已重新生成 %d 个分块的向量\n", cp.Done)
+	return nil
+}
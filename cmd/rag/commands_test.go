@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestInRoot(t *testing.T) {
+	for _, tc := range []struct {
+		root, filename string
+		want           bool
+	}{
+		{".", "docs/a.txt", true},
+		{".", "a.txt", true},
+		{".", "../a.txt", false},
+		{".", "/data/a.txt", false},
+		{"docs", "docs/a.txt", true},
+		{"docs", "docs/txt/a.txt", true},
+		{"docs", "docs2/a.txt", false},
+		{"docs", "..docs/a.txt", false},
+		{"docs/a.txt", "docs/a.txt", true},
+		{"/data", "/data/a.txt", true},
+		{"/data", "data/a.txt", false},
+	} {
+		if got := inRoot(tc.root, tc.filename); got != tc.want {
+			t.Errorf("inRoot(%q, %q) = %v, want %v", tc.root, tc.filename, got, tc.want)
+		}
+	}
+}
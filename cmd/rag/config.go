@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"study_langchain/pkg/mllm"
+)
+
+// loadConfig 加载配置文件和环境变量，再使用命令行中设置过的参数覆盖
+// 优先级：命令行参数 > 环境变量 > 配置文件 > 默认值，由 execute 校验覆盖后的配置
+func loadConfig(fs *flag.FlagSet, filename string, flags *mllm.Config) (*mllm.Config, error) {
+	cfg, err := mllm.LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "model":
+			cfg.Model = flags.Model
+		case "embedding-model":
+			cfg.EmbeddingModel = flags.EmbeddingModel
+		case "tenant":
+			cfg.Tenant = flags.Tenant
+		case "ollama":
+			cfg.OllamaURL = flags.OllamaURL
+		case "mongo":
+			cfg.Mongo.URI = flags.Mongo.URI
+		case "db":
+			cfg.Mongo.Database = flags.Mongo.Database
+		case "coll":
+			cfg.Mongo.Collection = flags.Mongo.Collection
+		case "index":
+			cfg.Mongo.Index = flags.Mongo.Index
+		case "dims":
+			cfg.Mongo.Dimensions = flags.Mongo.Dimensions
+		case "similarity":
+			cfg.Mongo.Similarity = flags.Mongo.Similarity
+		case "log-level":
+			cfg.Log.Level = flags.Log.Level
+		}
+	})
+	return cfg, nil
+}
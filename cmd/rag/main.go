@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"study_langchain/pkg/mllm"
+)
+
+const usage = `知识库管理工具
+
+用法：
+  rag [全局参数] <命令> [参数]
+
+命令：
+  ingest <路径...>          加载文件或目录并保存到向量库，已保存且未修改的文件会被跳过
+  sync <目录>               同步目录，重新加载修改过的文件，删除已不存在的文件
+  query [-k 4] <文本>       查询最相似的分块
+  ask [-route] <问题>       基于知识库回答问题，-route 时先根据知识库说明选择要检索的知识库
+  list                      列出已保存的文件
+  delete <文件...>          删除文件对应的分块
+  stats                     查看向量库统计信息
+  purge <租户>              删除租户的所有分块和缓存的回答
+  index [-timeout 10m] create|update|drop|list
+                            创建、更新、删除向量索引或查看所有索引，等待索引构建完成
+  migrate [-index 索引] [-dimensions 维度] [-embedding-model 模型] <新集合>
+                            创建新的集合和索引，重新生成向量后切换到新集合
+  reembed [-batch 100] [-checkpoint 文件]
+                            向量模型变化后重新生成向量，中断后从进度文件继续
+
+全局参数：
+`
+
+type command struct {
+	run       func(ctx context.Context, cfg *mllm.Config, client *mllm.Client, args []string) error
+	needStore bool // 是否需要初始化 mongodb store
+}
+
+var commands = map[string]command{
+	"ingest":  {run: runIngest, needStore: true},
+	"sync":    {run: runSync, needStore: true},
+	"query":   {run: runQuery, needStore: true},
+	"ask":     {run: runAsk, needStore: true},
+	"list":    {run: runList, needStore: true},
+	"delete":  {run: runDelete, needStore: true},
+	"stats":   {run: runStats, needStore: true},
+	"purge":   {run: runPurge, needStore: true},
+	"index":   {run: runIndex},
+	"migrate": {run: runMigrate, needStore: true},
+	"reembed": {run: runReEmbed, needStore: true},
+}
+
+func main() {
+	var (
+		flags      mllm.Config
+		configFile string
+		kbs        string
+	)
+	fs := flag.CommandLine
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&configFile, "config", "", "配置文件路径(.yaml/.toml)，环境变量 MLLM_CONFIG")
+	fs.StringVar(&flags.Model, "model", "", "模型名称，环境变量 MLLM_MODEL")
+	fs.StringVar(&flags.EmbeddingModel, "embedding-model", "", "向量模型名称，为空时使用 model，环境变量 MLLM_EMBEDDING_MODEL")
+	fs.StringVar(&flags.Tenant, "tenant", "", "租户，添加、查询和删除只作用于该租户的分块，环境变量 MLLM_TENANT")
+	fs.StringVar(&kbs, "kb", "", "知识库名称，多个以逗号分隔时同时检索，为空时使用 mongo.collection")
+	fs.StringVar(&flags.Log.Level, "log-level", "", "日志级别 debug|info|warn|error，debug 时输出每个阶段的耗时，环境变量 MLLM_LOG_LEVEL")
+	fs.StringVar(&flags.OllamaURL, "ollama", "", "ollama 地址，环境变量 MLLM_OLLAMA_URL")
+	fs.StringVar(&flags.Mongo.URI, "mongo", "", "mongodb 地址，环境变量 MLLM_MONGO_URI")
+	fs.StringVar(&flags.Mongo.Database, "db", "", "数据库名称，环境变量 MLLM_MONGO_DATABASE")
+	fs.StringVar(&flags.Mongo.Collection, "coll", "", "集合名称，环境变量 MLLM_MONGO_COLLECTION")
+	fs.StringVar(&flags.Mongo.Index, "index", "", "向量索引名称，环境变量 MLLM_MONGO_INDEX")
+	fs.IntVar(&flags.Mongo.Dimensions, "dims", 0, "向量维度，环境变量 MLLM_MONGO_DIMENSIONS")
+	fs.Func("similarity", "相似度算法 euclidean|cosine|dotProduct，环境变量 MLLM_MONGO_SIMILARITY", func(v string) error {
+		flags.Mongo.Similarity = mllm.FieldSimilarity(v)
+		return nil
+	})
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知命令：%s\n\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(fs, configFile, &flags)
+	if err != nil {
+		fatalf("读取配置失败：%s", err.Error())
+	}
+
+	logger := cfg.Log.NewLogger(os.Stderr)
+	slog.SetDefault(logger)
+
+	// 通过 ctx 传递观测钩子，不使用 Client 的 index 命令也会记录等待索引的耗时
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx = mllm.WithObservers(ctx, mllm.NewLogObserver(logger))
+	if cfg.Tenant != "" {
+		ctx = mllm.WithTenant(ctx, cfg.Tenant)
+	}
+	if kbs != "" {
+		ctx = mllm.WithKnowledgeBases(ctx, strings.Split(kbs, ",")...)
+	}
+
+	if err = execute(ctx, cmd, cfg, fs.Args()[1:]); err != nil {
+		fatalf("%s 执行失败：%s", fs.Arg(0), err.Error())
+	}
+}
+
+func execute(ctx context.Context, cmd command, cfg *mllm.Config, args []string) error {
+	// NewClientFromConfig 会校验配置，不需要 store 的命令单独校验
+	if !cmd.needStore {
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("配置错误：%w", err)
+		}
+		return cmd.run(ctx, cfg, nil, args)
+	}
+
+	client, err := mllm.NewClientFromConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("初始化失败（%s）：%w", cfg, err)
+	}
+	defer client.Close(context.Background())
+	return cmd.run(ctx, cfg, client, args)
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/schema"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"study_langchain/pkg/mllm"
+	"time"
+)
+
+const (
+	maxUploadSize = 32 << 20 // 上传文件最大32M
+	maxQueryLen   = 4096     // 问题最大长度
+	maxSearchK    = 50
+
+	tenantHeader = "X-Tenant"         // 请求的租户，由网关鉴权后设置
+	kbHeader     = "X-Knowledge-Base" // 请求的知识库，多个以逗号分隔
+	healthTenant = "_healthz"         // 健康检查使用的租户，只用于检查 mongodb 连接
+)
+
+// supportedExts 支持加载的文件类型，与 mllm 中的加载器保持一致
+var supportedExts = map[string]bool{".md": true, ".txt": true, ".pdf": true}
+
+type server struct {
+	client    *mllm.Client
+	docsRoot  string
+	uploadDir string
+}
+
+func newServer(client *mllm.Client, docsRoot, uploadDir string) *server {
+	return &server{client: client, docsRoot: docsRoot, uploadDir: uploadDir}
+}
+
+// routes 注册路由，extra 为额外的接口，例：OpenAI 兼容接口
+func (s *server) routes(extra ...interface{ register(mux *http.ServeMux) }) http.Handler {
+	mux := http.NewServeMux()
+	for _, e := range extra {
+		e.register(mux)
+	}
+	mux.HandleFunc("GET /healthz", s.health)
+	mux.HandleFunc("POST /api/ingest", s.ingest)
+	mux.HandleFunc("POST /api/ask", s.ask)
+	mux.HandleFunc("POST /api/search", s.search)
+	mux.HandleFunc("GET /api/sources", s.listSources)
+	mux.HandleFunc("DELETE /api/sources", s.deleteSources)
+	mux.HandleFunc("GET /api/stats", s.stats)
+	return logRequest(withTenant(mux))
+}
+
+// Source 回答和查询结果中引用的分块
+type Source struct {
+	Filename   string  `json:"filename"`
+	ChunkIndex any     `json:"chunk_index,omitempty"`
+	Page       any     `json:"page,omitempty"`
+	Section    any     `json:"section,omitempty"`
+	Score      float32 `json:"score,omitempty"`
+	Content    string  `json:"content"`
+}
+
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if _, ok := mllm.TenantFromContext(ctx); !ok {
+		ctx = mllm.WithTenant(ctx, healthTenant)
+	}
+
+	if _, err := s.client.Stats(ctx); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "mongodb不可用："+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "model": s.client.GetModel()})
+}
+
+// ingest 加载文档，支持 multipart 上传文件(file 字段)或 json {"path": "docs/txt"}
+func (s *server) ingest(w http.ResponseWriter, r *http.Request) {
+	var (
+		path string
+		err  error
+	)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// 同名文件重新上传时 AddDocuments 写入成功后删除之前的分块
+		path, err = s.saveUpload(w, r)
+	} else {
+		var req struct {
+			Path string `json:"path"`
+		}
+		if err = decodeJSON(w, r, &req); err == nil {
+			path, err = s.resolvePath(req.Path)
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ids, err := s.client.AddDocuments(r.Context(), path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"path": path, "chunks": len(ids)})
+}
+
+// resolvePath 校验路径，只允许访问 docsRoot 下的文件
+func (s *server) resolvePath(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("path 不能为空")
+	}
+
+	root, err := filepath.Abs(s.docsRoot)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(filepath.Join(s.docsRoot, path))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path 必须在 %s 目录下", s.docsRoot)
+	}
+	if _, err = os.Stat(abs); err != nil {
+		return "", fmt.Errorf("文件不存在：%s", path)
+	}
+	return filepath.Join(s.docsRoot, rel), nil
+}
+
+// saveUpload 保存上传的文件，返回保存后的路径
+func (s *server) saveUpload(w http.ResponseWriter, r *http.Request) (string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return "", fmt.Errorf("读取上传文件失败：%w", err)
+	}
+	defer file.Close()
+
+	name := filepath.Base(header.Filename)
+	if !supportedExts[filepath.Ext(name)] {
+		return "", errors.New("不支持的文档类型:" + filepath.Ext(name))
+	}
+	dir := s.uploadDirFor(r.Context())
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	// 先写入临时文件再重命名，同名文件正在加载时不会读到写了一半的内容
+	dst, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(dst.Name())
+	_, err = io.Copy(dst, file)
+	if err = errors.Join(err, dst.Close()); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name)
+	if err = os.Rename(dst.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// uploadDirFor 上传文件的保存目录，不同租户、知识库的文件分开保存，同名文件互不覆盖：
+// uploadDir[/t/租户][/kb/知识库]
+func (s *server) uploadDirFor(ctx context.Context) string {
+	dir := s.uploadDir
+	if tenant, ok := mllm.TenantFromContext(ctx); ok {
+		dir = filepath.Join(dir, "t", pathSegment(tenant))
+	}
+	if kbs := mllm.KnowledgeBasesFromContext(ctx); len(kbs) > 0 {
+		dir = filepath.Join(dir, "kb", pathSegment(strings.Join(kbs, ",")))
+	}
+	return dir
+}
+
+// pathSegment 将请求头中的值转换为单级目录名，不会包含路径分隔符或 ..
+func pathSegment(s string) string {
+	s = url.PathEscape(s)
+	if s == "." || s == ".." {
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	return s
+}
+
+type askRequest struct {
+	Question string `json:"question"`
+	Stream   bool   `json:"stream"`
+}
+
+// ask 回答问题，stream 为 true 或 Accept 为 text/event-stream 时使用 SSE 返回
+func (s *server) ask(w http.ResponseWriter, r *http.Request) {
+	var req askRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateQuery(req.Question); err != nil {
+		writeError(w, http.StatusBadRequest, "question "+err.Error())
+		return
+	}
+
+	if !req.Stream && r.Header.Get("Accept") != "text/event-stream" {
+		res, err := s.client.Chain(r.Context(), req.Question)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"answer": res["text"], "sources": toSources(res["source_documents"])})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	res, err := s.client.Chain(r.Context(), req.Question, chains.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+		if err := writeEvent(w, "message", map[string]string{"content": string(chunk)}); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}))
+	if err != nil {
+		writeEvent(w, "error", map[string]string{"error": err.Error()})
+	} else {
+		writeEvent(w, "done", map[string]any{"answer": res["text"], "sources": toSources(res["source_documents"])})
+	}
+	flusher.Flush()
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query string `json:"query"`
+		K     int    `json:"k"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateQuery(req.Query); err != nil {
+		writeError(w, http.StatusBadRequest, "query "+err.Error())
+		return
+	}
+	if req.K == 0 {
+		req.K = 4
+	}
+	if req.K < 1 || req.K > maxSearchK {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("k 必须在 1-%d 之间", maxSearchK))
+		return
+	}
+
+	docs, err := s.client.Search(r.Context(), req.Query, req.K)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": toSources(docs)})
+}
+
+func (s *server) listSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := s.client.ListSources(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sources": sources})
+}
+
+// deleteSources 删除文件，使用 ?filename=a&filename=b 指定文件
+func (s *server) deleteSources(w http.ResponseWriter, r *http.Request) {
+	filenames := r.URL.Query()["filename"]
+	if len(filenames) < 1 {
+		writeError(w, http.StatusBadRequest, "filename 不能为空")
+		return
+	}
+
+	n, err := s.client.DeleteBySource(r.Context(), filenames...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deleted": n})
+}
+
+func (s *server) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.client.Stats(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func validateQuery(q string) error {
+	switch q = strings.TrimSpace(q); {
+	case q == "":
+		return errors.New("不能为空")
+	case len([]rune(q)) > maxQueryLen:
+		return fmt.Errorf("长度不能超过%d", maxQueryLen)
+	}
+	return nil
+}
+
+func toSources(v any) []Source {
+	docs, _ := v.([]schema.Document)
+	sources := make([]Source, 0, len(docs))
+	for _, doc := range docs {
+		filename, _ := doc.Metadata[mllm.FilenameKey].(string)
+		sources = append(sources, Source{
+			Filename:   filename,
+			ChunkIndex: doc.Metadata[mllm.ChunkIndexKey],
+			Page:       doc.Metadata[mllm.PageKey],
+			Section:    doc.Metadata[mllm.SectionKey],
+			Score:      doc.Score,
+			Content:    doc.PageContent,
+		})
+	}
+	return sources
+}
+
+// decodeJSON 解析请求体，不允许出现未知字段
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("请求参数错误：%w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("返回数据失败", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeEvent(w io.Writer, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// metricsRoute 按 Prometheus 文本格式导出指标
+type metricsRoute struct {
+	metrics *mllm.Metrics
+}
+
+func (m metricsRoute) register(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", m.metrics)
+}
+
+// withTenant 将请求头中的租户和知识库写入 ctx，知识库的操作只作用于该租户
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if tenant := r.Header.Get(tenantHeader); tenant != "" {
+			ctx = mllm.WithTenant(ctx, tenant)
+		}
+		if kb := r.Header.Get(kbHeader); kb != "" {
+			ctx = mllm.WithKnowledgeBases(ctx, strings.Split(kb, ",")...)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		slog.Info("请求", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start))
+	})
+}
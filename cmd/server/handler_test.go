@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"study_langchain/pkg/mllm"
+	"testing"
+)
+
+func TestUploadDirFor(t *testing.T) {
+	s := &server{uploadDir: "uploads"}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{ctx, "uploads"},
+		{mllm.WithTenant(ctx, "acme"), filepath.Join("uploads", "t", "acme")},
+		{mllm.WithKnowledgeBases(mllm.WithTenant(ctx, "acme"), "docs"), filepath.Join("uploads", "t", "acme", "kb", "docs")},
+		// 请求头中的值不能跳出上传目录
+		{mllm.WithTenant(ctx, "../other"), filepath.Join("uploads", "t", "..%2Fother")},
+		{mllm.WithTenant(ctx, ".."), filepath.Join("uploads", "t", "%2E%2E")},
+	} {
+		if got := s.uploadDirFor(tc.ctx); got != tc.want {
+			t.Errorf("uploadDirFor = %q, want %q", got, tc.want)
+		}
+	}
+}
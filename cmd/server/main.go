@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"study_langchain/pkg/mllm"
+	"syscall"
+	"time"
+)
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("服务异常退出", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run 启动服务直到收到退出信号或服务启动失败，返回前关闭 mongodb 连接
+func run() error {
+	var (
+		addr       = flag.String("addr", ":8080", "监听地址")
+		configFile = flag.String("config", "", "配置文件路径(.yaml/.toml)，环境变量 MLLM_CONFIG")
+		docsRoot   = flag.String("docs-root", "./docs", "通过路径加载文档时允许访问的目录")
+		uploadDir  = flag.String("upload-dir", "./uploads", "上传文件的保存目录")
+		openaiRAGK = flag.Int("openai-rag-k", 4, "OpenAI 兼容接口每次对话检索的分块数量，为0时不检索知识库")
+		apiKey     = flag.String("api-key", os.Getenv("MLLM_API_KEY"), "OpenAI 兼容接口的 api key，为空时不校验，环境变量 MLLM_API_KEY")
+	)
+	flag.Parse()
+
+	cfg, err := mllm.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("读取配置失败：%w", err)
+	}
+	logger := cfg.Log.NewLogger(os.Stderr)
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := mllm.NewClientFromConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("初始化失败：%w，配置：%s", err, cfg.String())
+	}
+	defer client.Close(context.Background())
+
+	// 记录各阶段的日志、指标和 span，span 使用全局的 TracerProvider
+	metrics := mllm.NewMetrics()
+	client.SetObservers(mllm.NewLogObserver(logger), metrics, mllm.NewTraceObserver(nil))
+
+	// 提前初始化向量库，避免并发请求时重复创建
+	if _, err = client.GetStore(); err != nil {
+		return fmt.Errorf("初始化向量库失败：%w", err)
+	}
+
+	s := newServer(client, *docsRoot, *uploadDir)
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(&openAI{server: s, ragK: *openaiRAGK, apiKey: *apiKey}, metricsRoute{metrics}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("服务启动", "addr", *addr, "config", cfg.String())
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errc <- fmt.Errorf("服务启动失败：%w", err)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		slog.Info("服务关闭中...")
+	case err = <-errc:
+		return err
+	}
+
+	// 等待正在处理的请求完成
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("服务关闭失败", "error", err)
+	}
+	return nil
+}
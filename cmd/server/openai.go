@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI 兼容接口，参考 https://platform.openai.com/docs/api-reference/chat
+
+type openAIMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // 字符串或 [{"type":"text","text":"..."}]
+}
+
+type chatCompletionRequest struct {
+	Model       string          `json:"model"`
+	Messages    []openAIMessage `json:"messages"`
+	Stream      bool            `json:"stream"`
+	Temperature *float64        `json:"temperature"`
+	TopP        *float64        `json:"top_p"`
+	MaxTokens   int             `json:"max_tokens"`
+	Stop        json.RawMessage `json:"stop"` // 字符串或字符串数组
+}
+
+type chatCompletionChoice struct {
+	Index        int            `json:"index"`
+	Message      *responseDelta `json:"message,omitempty"`
+	Delta        *responseDelta `json:"delta,omitempty"`
+	FinishReason *string        `json:"finish_reason"`
+}
+
+type responseDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+	Usage   *usage                 `json:"usage,omitempty"`
+}
+
+// openAI OpenAI 兼容接口，ragK 为每次对话检索的分块数量，为0时不检索知识库
+type openAI struct {
+	*server
+	ragK   int
+	apiKey string
+}
+
+func (o *openAI) register(mux *http.ServeMux) {
+	mux.Handle("GET /v1/models", o.auth(o.models))
+	mux.Handle("POST /v1/chat/completions", o.auth(o.chatCompletions))
+}
+
+// auth 设置了 apiKey 时校验 Authorization: Bearer <apiKey>
+func (o *openAI) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, want := []byte(r.Header.Get("Authorization")), []byte("Bearer "+o.apiKey)
+		if o.apiKey != "" && subtle.ConstantTimeCompare(got, want) != 1 {
+			writeOpenAIError(w, http.StatusUnauthorized, "invalid_api_key", "无效的 api key")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (o *openAI) models(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"object": "list",
+		"data": []map[string]any{
+			{"id": o.client.GetModel(), "object": "model", "created": 0, "owned_by": "ollama"},
+		},
+	})
+}
+
+func (o *openAI) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "请求参数错误："+err.Error())
+		return
+	}
+
+	messages, err := toMessages(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	opts, err := req.callOptions()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// 只支持 /v1/models 中列出的模型
+	model := o.client.GetModel()
+	if req.Model != "" && req.Model != model {
+		writeOpenAIError(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("模型 %s 不存在，可用模型：%s", req.Model, model))
+		return
+	}
+	resp := chatCompletionResponse{
+		ID:      "chatcmpl-" + randomID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+
+	if !req.Stream {
+		res, err := o.client.Chat(r.Context(), messages, o.ragK, opts...)
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		resp.Choices = []chatCompletionChoice{{
+			Message:      &responseDelta{Role: "assistant", Content: res.Content},
+			FinishReason: ptr("stop"),
+		}}
+		resp.Usage = &usage{res.PromptTokens, res.CompletionTokens, res.PromptTokens + res.CompletionTokens}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "不支持流式响应")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	resp.Object = "chat.completion.chunk"
+	send := func(delta responseDelta, finish *string) error {
+		resp.Choices = []chatCompletionChoice{{Delta: &delta, FinishReason: finish}}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	// 第一个分块返回角色信息
+	if err = send(responseDelta{Role: "assistant"}, nil); err != nil {
+		return
+	}
+	opts = append(opts, llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+		return send(responseDelta{Content: string(chunk)}, nil)
+	}))
+	if _, err = o.client.Chat(r.Context(), messages, o.ragK, opts...); err != nil {
+		data, _ := json.Marshal(openAIError(err.Error(), "server_error"))
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	} else {
+		send(responseDelta{}, ptr("stop"))
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// toMessages 将 OpenAI 格式的消息转换为 llms.MessageContent
+func toMessages(msgs []openAIMessage) ([]llms.MessageContent, error) {
+	if len(msgs) < 1 {
+		return nil, errors.New("messages 不能为空")
+	}
+
+	res := make([]llms.MessageContent, 0, len(msgs))
+	for i, m := range msgs {
+		var role llms.ChatMessageType
+		switch m.Role {
+		case "system", "developer":
+			role = llms.ChatMessageTypeSystem
+		case "user":
+			role = llms.ChatMessageTypeHuman
+		case "assistant":
+			role = llms.ChatMessageTypeAI
+		default:
+			return nil, fmt.Errorf("messages[%d].role 不支持 %q", i, m.Role)
+		}
+
+		text, err := contentText(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d].content %w", i, err)
+		}
+		res = append(res, llms.TextParts(role, text))
+	}
+	if res[len(res)-1].Role != llms.ChatMessageTypeHuman {
+		return nil, errors.New("最后一条消息必须是 user 消息")
+	}
+	return res, nil
+}
+
+// contentText 解析消息内容，只支持文本
+func contentText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", errors.New("格式错误")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("不支持 %s 类型", p.Type)
+		}
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func (r chatCompletionRequest) callOptions() ([]llms.CallOption, error) {
+	opts := make([]llms.CallOption, 0, 4)
+	if r.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*r.Temperature))
+	}
+	if r.TopP != nil {
+		opts = append(opts, llms.WithTopP(*r.TopP))
+	}
+	if r.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(r.MaxTokens))
+	}
+
+	if len(r.Stop) > 0 && string(r.Stop) != "null" {
+		var stop []string
+		if err := json.Unmarshal(r.Stop, &stop); err != nil {
+			var s string
+			if err = json.Unmarshal(r.Stop, &s); err != nil {
+				return nil, errors.New("stop 格式错误")
+			}
+			stop = []string{s}
+		}
+		opts = append(opts, llms.WithStopWords(stop))
+	}
+	return opts, nil
+}
+
+func openAIError(msg, typ string) map[string]any {
+	return map[string]any{"error": map[string]any{"message": msg, "type": typ, "code": nil}}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, typ, msg string) {
+	writeJSON(w, status, openAIError(msg, typ))
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
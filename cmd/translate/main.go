@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"study_langchain/pkg/mllm"
+)
+
+func main() {
+	var (
+		configFile = flag.String("config", "", "配置文件路径(.yaml/.toml)，环境变量 MLLM_CONFIG")
+		from       = flag.String("from", "", "源语言，为空时自动识别")
+		to         = flag.String("to", "Chinese", "目标语言")
+		glossary   = flag.String("glossary", "", "术语表文件(yaml，原文术语: 译法)")
+		retries    = flag.Int("retries", 2, "格式错误或术语不符合时的重试次数")
+		markdown   = flag.Bool("markdown", false, "按 Markdown 文档翻译，保留文档结构")
+		chunkSize  = flag.Int("chunk-size", 1000, "Markdown 文档合并翻译的段落字符数")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "用法：translate [参数] [文件|文本]，不指定时从标准输入读取\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	text, err := readInput(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("读取输入失败：%s", err.Error())
+	}
+
+	opt := mllm.TranslateOptions{From: *from, To: *to, Retries: *retries}
+	if *glossary != "" {
+		data, err := os.ReadFile(*glossary)
+		if err != nil {
+			log.Fatalf("读取术语表失败：%s", err.Error())
+		}
+		if err = yaml.Unmarshal(data, &opt.Glossary); err != nil {
+			log.Fatalf("解析术语表失败：%s", err.Error())
+		}
+	}
+
+	cfg, err := mllm.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("读取配置失败：%s", err.Error())
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatalf("配置错误：%s", err.Error())
+	}
+	client, err := mllm.NewLLM(cfg.Model, cfg.OllamaURL)
+	if err != nil {
+		log.Fatalf("llm初始化失败：%s", err.Error())
+	}
+
+	ctx := context.Background()
+	var results []*mllm.Translation
+	if *markdown {
+		var out string
+		out, results, err = client.TranslateMarkdown(ctx, text, *chunkSize, opt)
+		if err != nil {
+			log.Fatalf("翻译失败：%s", err.Error())
+		}
+		fmt.Println(out)
+	} else {
+		res, err := client.Translate(ctx, text, opt)
+		if err != nil {
+			log.Fatalf("翻译失败：%s", err.Error())
+		}
+		results = append(results, res)
+		fmt.Println(res.Text)
+	}
+
+	for _, res := range results {
+		if len(res.Missing) > 0 {
+			log.Printf("术语未按术语表翻译：%s", strings.Join(res.Missing, "、"))
+		}
+	}
+}
+
+// readInput 参数为文件时读取文件内容，否则作为文本，为空时读取标准输入
+func readInput(arg string) (string, error) {
+	if arg == "" {
+		data, err := io.ReadAll(os.Stdin)
+		return string(data), err
+	}
+	if data, err := os.ReadFile(arg); err == nil {
+		return string(data), nil
+	}
+	return arg, nil
+}
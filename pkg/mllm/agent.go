@@ -0,0 +1,255 @@
+package mllm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"reflect"
+	"strings"
+)
+
+// ErrMaxSteps 超过最大步数仍没有得到最终回答
+var ErrMaxSteps = errors.New("超过最大步数")
+
+// Tool 可以被模型调用的工具，Parameters 为参数的 JSON Schema
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+	Call        func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// NewTool 将 Go 函数包装为工具，参数的 JSON Schema 由 T 生成，规则与 Structured 相同，
+// 调用前按 schema 校验模型传入的参数，T 实现 Validator 时同时进行业务校验
+func NewTool[T any](name, description string, fn func(ctx context.Context, args T) (string, error)) Tool {
+	params := JSONSchema(reflect.TypeFor[T]())
+	return Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  params,
+		Call: func(ctx context.Context, args json.RawMessage) (string, error) {
+			if len(args) == 0 {
+				args = json.RawMessage("{}")
+			}
+			v, err := parseStructured[T](string(args), params)
+			if err != nil {
+				return "", fmt.Errorf("参数错误：%w", err)
+			}
+			return fn(ctx, *v)
+		},
+	}
+}
+
+// ToolCall 一次工具调用
+type ToolCall struct {
+	Name      string
+	Arguments json.RawMessage
+	Result    string
+	Err       error
+}
+
+// AgentResult 智能体的执行结果
+type AgentResult struct {
+	Content          string
+	Steps            []ToolCall        // 按顺序执行的工具调用
+	Sources          []schema.Document // 知识库检索工具检索到的分块
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Agent 工具调用循环：模型返回工具调用时执行工具并将结果返回给模型，直到模型给出最终回答
+// langchaingo 的 ollama 客户端不支持工具调用，这里直接调用 ollama 的 /api/chat 接口
+type Agent struct {
+	client   *Client
+	model    string
+	tools    map[string]Tool
+	names    []string // 工具注册顺序
+	maxSteps int
+	searchK  int // 大于0时注册知识库检索工具
+}
+
+type AgentOption func(*Agent)
+
+// WithTools 注册工具，同名工具后注册的覆盖先注册的
+func WithTools(tools ...Tool) AgentOption {
+	return func(a *Agent) {
+		for _, t := range tools {
+			if _, ok := a.tools[t.Name]; !ok {
+				a.names = append(a.names, t.Name)
+			}
+			a.tools[t.Name] = t
+		}
+	}
+}
+
+// WithMaxSteps 设置最多调用模型的次数
+func WithMaxSteps(n int) AgentOption {
+	return func(a *Agent) {
+		a.maxSteps = n
+	}
+}
+
+// WithAgentModel 设置使用的模型，模型需要支持工具调用
+func WithAgentModel(model string) AgentOption {
+	return func(a *Agent) {
+		a.model = model
+	}
+}
+
+// WithSearchTool 注册知识库检索工具 search_knowledge_base，每次检索 k 个分块
+func WithSearchTool(k int) AgentOption {
+	return func(a *Agent) {
+		a.searchK = k
+	}
+}
+
+// NewAgent 创建智能体
+func (c *Client) NewAgent(opts ...AgentOption) *Agent {
+	a := &Agent{client: c, model: c.model, tools: make(map[string]Tool), maxSteps: 8}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+type searchArgs struct {
+	Query string `json:"query" describe:"检索内容，使用完整的问题或关键词"`
+}
+
+// searchTool 知识库检索工具，检索到的分块记录到 res.Sources
+func (a *Agent) searchTool(res *AgentResult) Tool {
+	return NewTool("search_knowledge_base", "在知识库中检索与问题相关的内容，回答需要依据知识库的问题时使用",
+		func(ctx context.Context, args searchArgs) (string, error) {
+			docs, err := a.client.Search(ctx, args.Query, a.searchK)
+			if err != nil {
+				return "", err
+			}
+			res.Sources = append(res.Sources, docs...)
+			if len(docs) < 1 {
+				return "没有检索到相关内容", nil
+			}
+
+			var sb strings.Builder
+			for i, doc := range docs {
+				fmt.Fprintf(&sb, "[%d] %v\n%s\n\n", i+1, doc.Metadata[FilenameKey], doc.PageContent)
+			}
+			return sb.String(), nil
+		})
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// Run 执行工具调用循环，messages 只支持文本内容
+func (a *Agent) Run(ctx context.Context, messages []llms.MessageContent) (*AgentResult, error) {
+	res := &AgentResult{}
+	tools := make(map[string]Tool, len(a.tools)+1)
+	names := append([]string{}, a.names...)
+	for name, t := range a.tools {
+		tools[name] = t
+	}
+	if a.searchK > 0 {
+		t := a.searchTool(res)
+		if _, ok := tools[t.Name]; !ok {
+			names = append(names, t.Name)
+		}
+		tools[t.Name] = t
+	}
+
+	defs := make([]ollamaTool, 0, len(names))
+	for _, name := range names {
+		t := tools[name]
+		def := ollamaTool{Type: "function"}
+		def.Function.Name, def.Function.Description, def.Function.Parameters = t.Name, t.Description, t.Parameters
+		defs = append(defs, def)
+	}
+
+	msgs, err := toOllamaMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	for step := 0; step < a.maxSteps; step++ {
+		var resp ollamaChatResponse
+		body := map[string]any{"model": a.model, "messages": msgs, "tools": defs, "stream": false}
+		if err = a.client.postOllama(ctx, "/api/chat", body, &resp); err != nil {
+			return nil, err
+		}
+		res.PromptTokens += resp.PromptEvalCount
+		res.CompletionTokens += resp.EvalCount
+
+		msgs = append(msgs, resp.Message)
+		if len(resp.Message.ToolCalls) < 1 {
+			res.Content = resp.Message.Content
+			return res, nil
+		}
+
+		for _, call := range resp.Message.ToolCalls {
+			tc := ToolCall{Name: call.Function.Name, Arguments: call.Function.Arguments}
+			if t, ok := tools[tc.Name]; ok {
+				tc.Result, tc.Err = t.Call(ctx, tc.Arguments)
+			} else {
+				tc.Err = fmt.Errorf("工具 %s 不存在", tc.Name)
+			}
+			res.Steps = append(res.Steps, tc)
+
+			// 调用失败时将错误返回给模型，由模型决定修正参数或直接回答
+			content := tc.Result
+			if tc.Err != nil {
+				content = "调用失败：" + tc.Err.Error()
+			}
+			msgs = append(msgs, ollamaMessage{Role: "tool", Content: content, ToolName: tc.Name})
+		}
+	}
+	return res, fmt.Errorf("%w %d", ErrMaxSteps, a.maxSteps)
+}
+
+func toOllamaMessages(messages []llms.MessageContent) ([]ollamaMessage, error) {
+	res := make([]ollamaMessage, 0, len(messages))
+	for _, m := range messages {
+		var role string
+		switch m.Role {
+		case llms.ChatMessageTypeSystem:
+			role = "system"
+		case llms.ChatMessageTypeHuman, llms.ChatMessageTypeGeneric:
+			role = "user"
+		case llms.ChatMessageTypeAI:
+			role = "assistant"
+		case llms.ChatMessageTypeTool:
+			role = "tool"
+		default:
+			return nil, fmt.Errorf("不支持的消息类型 %s", m.Role)
+		}
+		res = append(res, ollamaMessage{Role: role, Content: partsText(m.Parts)})
+	}
+	return res, nil
+}
@@ -0,0 +1,102 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+)
+
+type addArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+var addTool = NewTool("add", "计算两个整数的和", func(_ context.Context, args addArgs) (string, error) {
+	return fmt.Sprint(args.A + args.B), nil
+})
+
+func runAgent(t *testing.T, c *Client, opts ...AgentOption) (*AgentResult, error) {
+	t.Helper()
+	agent := c.NewAgent(append([]AgentOption{WithTools(addTool)}, opts...)...)
+	return agent.Run(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "1 加 2 等于多少")})
+}
+
+// lastMessage 第 i 次对话请求的最后一条消息
+func lastMessage(t *testing.T, srv *ollamatest.Server, i int) ollamatest.Message {
+	t.Helper()
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) <= i {
+		t.Fatalf("chat requests = %d", len(reqs))
+	}
+	msgs := reqs[i].Messages
+	return msgs[len(msgs)-1]
+}
+
+func TestAgentToolCall(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.Reply(
+		ollamatest.Response{ToolCalls: []ollamatest.ToolCall{{Name: "add", Arguments: map[string]any{"a": 1, "b": 2}}}},
+		ollamatest.Response{Content: "等于3"},
+	)
+
+	res, err := runAgent(t, c)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Content != "等于3" || len(res.Steps) != 1 || res.Steps[0].Result != "3" || res.Steps[0].Err != nil {
+		t.Fatalf("res = %+v", res)
+	}
+	if reqs := srv.RequestsTo("/api/chat"); len(reqs) != 2 || reqs[0].Tools != 1 {
+		t.Fatalf("requests = %+v", reqs)
+	}
+	// 工具的结果返回给模型
+	if m := lastMessage(t, srv, 1); m.Role != "tool" || m.ToolName != "add" || m.Content != "3" {
+		t.Fatalf("tool message = %+v", m)
+	}
+}
+
+func TestAgentToolErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		call ollamatest.ToolCall
+		want string
+	}{
+		{"unknown tool", ollamatest.ToolCall{Name: "sub", Arguments: map[string]any{"a": 1, "b": 2}}, "工具 sub 不存在"},
+		{"bad arguments", ollamatest.ToolCall{Name: "add", Arguments: map[string]any{"a": "x", "b": 2}}, "参数错误"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, srv := newTestClient(t)
+			srv.Reply(ollamatest.Response{ToolCalls: []ollamatest.ToolCall{tc.call}}, ollamatest.Response{Content: "无法计算"})
+
+			res, err := runAgent(t, c)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if res.Content != "无法计算" || len(res.Steps) != 1 || res.Steps[0].Err == nil || !strings.Contains(res.Steps[0].Err.Error(), tc.want) {
+				t.Fatalf("res = %+v", res)
+			}
+			// 错误返回给模型，由模型决定下一步
+			if m := lastMessage(t, srv, 1); m.Role != "tool" || !strings.HasPrefix(m.Content, "调用失败：") || !strings.Contains(m.Content, tc.want) {
+				t.Fatalf("tool message = %+v", m)
+			}
+		})
+	}
+}
+
+func TestAgentMaxSteps(t *testing.T) {
+	c, srv := newTestClient(t)
+	call := ollamatest.Response{ToolCalls: []ollamatest.ToolCall{{Name: "add", Arguments: map[string]any{"a": 1, "b": 2}}}}
+	srv.Reply(call, call, call)
+
+	res, err := runAgent(t, c, WithMaxSteps(2))
+	if !errors.Is(err, ErrMaxSteps) {
+		t.Fatalf("err = %v, want ErrMaxSteps", err)
+	}
+	if len(res.Steps) != 2 || len(srv.RequestsTo("/api/chat")) != 2 {
+		t.Fatalf("steps = %d, requests = %d", len(res.Steps), len(srv.RequestsTo("/api/chat")))
+	}
+}
@@ -0,0 +1,323 @@
+package mllm
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 语义缓存中写入到元数据的字段，问题保存在 page_content 中
+var (
+	AnswerKey       = "answer"        // 缓存的回答
+	AnswerDocsKey   = "answer_docs"   // 回答引用的分块，json 格式
+	SourceFilesKey  = "source_files"  // 回答引用的文件，文件更新时删除缓存
+	QuestionHashKey = "question_hash" // 问题的 hash，用于删除和去重
+	CreatedTimeKey  = "created_time"  // 缓存时间，RFC3339 格式
+)
+
+// AnswerCache Chain 的语义缓存，问题与已缓存问题的相似度超过阈值、
+// 并且引用的文件没有更新时直接返回缓存的回答
+type AnswerCache struct {
+	store     Store
+	threshold float32       // 相似度阈值，与向量检索的分数一致
+	ttl       time.Duration // 缓存有效期，为0时不过期
+	k         int           // 每次检索的候选数量
+	counters  cacheCounters
+}
+
+type AnswerCacheOption func(*AnswerCache)
+
+// WithAnswerThreshold 设置相似度阈值，范围 (0, 1]，默认 0.95
+func WithAnswerThreshold(score float32) AnswerCacheOption {
+	return func(a *AnswerCache) {
+		a.threshold = score
+	}
+}
+
+// WithAnswerTTL 设置缓存有效期，默认不过期
+func WithAnswerTTL(ttl time.Duration) AnswerCacheOption {
+	return func(a *AnswerCache) {
+		a.ttl = ttl
+	}
+}
+
+// SetAnswerCache 在当前数据库的 collname 集合中缓存 Chain 的回答，集合和索引不存在时自动创建；
+// AddDocuments 更新文件时删除引用了该文件的缓存
+func (c *Client) SetAnswerCache(ctx context.Context, collname string, opts ...AnswerCacheOption) error {
+	backend := c.getBackend()
+	if backend == nil {
+		return errors.New("未设置mongodb store")
+	}
+
+	a := &AnswerCache{store: backend.Collection(collname), threshold: 0.95, k: 3}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.threshold <= 0 || a.threshold > 1 {
+		return errors.New("相似度阈值的范围为 (0, 1]")
+	}
+	if err := ensureStore(c.observe(ctx), a.store); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.answers = a
+	c.mu.Unlock()
+	return nil
+}
+
+// AnswerCacheStats 语义缓存的命中统计，没有设置缓存时都为0
+func (c *Client) AnswerCacheStats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.answers == nil {
+		return CacheStats{}
+	}
+	return c.answers.counters.stats()
+}
+
+func questionHash(query string) string {
+	sum := sha1.Sum([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// answerVectorStore 使用当前向量模型的缓存集合
+func (c *Client) answerVectorStore(ctx context.Context, a *AnswerCache) (vectorstores.VectorStore, error) {
+	model, err := c.embeddingCacheModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	emb, err := c.embedder()
+	if err != nil {
+		return nil, err
+	}
+	return a.store.VectorStore(c.cachedEmbedder(emb, model)), nil
+}
+
+// lookupAnswer 查找缓存的回答，读取缓存失败时当作未命中
+func (c *Client) lookupAnswer(ctx context.Context, a *AnswerCache, query string) (map[string]any, bool) {
+	res, err := c.findAnswer(ctx, a, query)
+	switch {
+	case err != nil:
+		a.counters.errors.Add(1)
+		a.counters.misses.Add(1)
+	case res == nil:
+		a.counters.misses.Add(1)
+	default:
+		a.counters.hits.Add(1)
+	}
+	return res, res != nil
+}
+
+func (c *Client) findAnswer(ctx context.Context, a *AnswerCache, query string) (map[string]any, error) {
+	vs, err := c.answerVectorStore(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	model, version, err := c.EmbeddingModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := c.scopeSearch(ctx, []vectorstores.Option{vectorstores.WithScoreThreshold(a.threshold)}, a.store)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := vs.SimilaritySearch(ctx, query, a.k, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := answerScope(ctx)
+	tenant, _ := TenantFromContext(ctx)
+	for _, doc := range docs {
+		// 索引中没有租户字段时无法在检索中过滤，只使用当前租户的回答
+		if t, _ := doc.Metadata[TenantKey].(string); t != tenant {
+			continue
+		}
+		// 向量模型变化后的缓存不可用
+		if doc.Metadata[EmbeddingModelKey] != model || doc.Metadata[EmbeddingVersionKey] != version {
+			continue
+		}
+		// 只使用检索相同知识库得到的回答
+		if kb, _ := doc.Metadata[KnowledgeBaseKey].(string); kb != scope {
+			continue
+		}
+		if a.ttl > 0 {
+			created, _ := doc.Metadata[CreatedTimeKey].(string)
+			t, err := time.Parse(time.RFC3339, created)
+			if err != nil || time.Since(t) > a.ttl {
+				continue
+			}
+		}
+
+		var sources []schema.Document
+		data, _ := doc.Metadata[AnswerDocsKey].(string)
+		if err = json.Unmarshal([]byte(data), &sources); err != nil {
+			return nil, err
+		}
+		fresh, err := c.sourcesUnchanged(ctx, sources)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			hash, _ := doc.Metadata[QuestionHashKey].(string)
+			if err = c.deleteAnswers(ctx, a, bson.M{"metadata." + QuestionHashKey: hash}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		answer, _ := doc.Metadata[AnswerKey].(string)
+		return map[string]any{"text": answer, "source_documents": sources, "cached": true}, nil
+	}
+	return nil, nil
+}
+
+// sourcesUnchanged 引用的文件都存在并且只有缓存时的版本，分块来自多个知识库时在分块所在的知识库中查询
+func (c *Client) sourcesUnchanged(ctx context.Context, docs []schema.Document) (bool, error) {
+	checked := make(map[string]bool)
+	for _, doc := range docs {
+		filename, _ := doc.Metadata[FilenameKey].(string)
+		updated, _ := doc.Metadata[UpdatedTime].(string)
+		kb, _ := doc.Metadata[KnowledgeBaseKey].(string)
+		if filename == "" || checked[kb+"\x00"+filename] {
+			continue
+		}
+		checked[kb+"\x00"+filename] = true
+
+		var (
+			backend Store
+			err     error
+		)
+		if kb != "" {
+			backend, err = c.knowledgeBase(kb)
+		} else {
+			backend, err = c.backendFor(ctx)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		filter, err := c.scopeFilter(ctx, bson.M{"metadata." + FilenameKey: filename})
+		if err != nil {
+			return false, err
+		}
+		all, err := backend.Count(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		filter["metadata."+UpdatedTime] = updated
+		same, err := backend.Count(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		if same < 1 || same != all {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// saveAnswer 缓存 Chain 的回答，写入失败时只记录错误次数
+func (c *Client) saveAnswer(ctx context.Context, a *AnswerCache, query string, res map[string]any) {
+	if err := c.storeAnswer(ctx, a, query, res); err != nil {
+		a.counters.errors.Add(1)
+	}
+}
+
+func (c *Client) storeAnswer(ctx context.Context, a *AnswerCache, query string, res map[string]any) error {
+	answer, _ := res["text"].(string)
+	if answer == "" {
+		return nil
+	}
+	docs, _ := res["source_documents"].([]schema.Document)
+	data, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(docs))
+	seen := make(map[string]bool)
+	for _, doc := range docs {
+		if f, _ := doc.Metadata[FilenameKey].(string); f != "" && !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+	}
+
+	vs, err := c.answerVectorStore(ctx, a)
+	if err != nil {
+		return err
+	}
+	model, version, err := c.EmbeddingModel(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 同一个问题只保留最新的回答
+	hash := questionHash(query)
+	if err = c.deleteAnswers(ctx, a, bson.M{"metadata." + QuestionHashKey: hash}); err != nil {
+		return err
+	}
+	doc := schema.Document{PageContent: query, Metadata: map[string]any{
+		AnswerKey:       answer,
+		AnswerDocsKey:   string(data),
+		SourceFilesKey:  files,
+		QuestionHashKey: hash,
+		CreatedTimeKey:  time.Now().Format(time.RFC3339),
+	}}
+	if tenant, ok := TenantFromContext(ctx); ok {
+		doc.Metadata[TenantKey] = tenant
+	}
+	if scope := answerScope(ctx); scope != "" {
+		doc.Metadata[KnowledgeBaseKey] = scope
+	}
+	setEmbeddingMetadata([]schema.Document{doc}, model, version)
+	_, err = vs.AddDocuments(ctx, []schema.Document{doc})
+	return err
+}
+
+// answerScope 缓存的回答对应的知识库，多个知识库按名称排序后以逗号分隔，未指定时为空
+func answerScope(ctx context.Context) string {
+	names := slices.Clone(KnowledgeBasesFromContext(ctx))
+	sort.Strings(names)
+	return strings.Join(slices.Compact(names), ",")
+}
+
+// invalidateAnswers 删除引用了 files 的缓存
+func (c *Client) invalidateAnswers(ctx context.Context, files []string) error {
+	c.mu.RLock()
+	a := c.answers
+	c.mu.RUnlock()
+	if a == nil || len(files) < 1 {
+		return nil
+	}
+
+	err := c.deleteAnswers(ctx, a, bson.M{"metadata." + SourceFilesKey: bson.M{"$in": files}})
+	if err != nil {
+		a.counters.errors.Add(1)
+	}
+	return err
+}
+
+// deleteAnswers 删除当前租户中符合 filter 的缓存
+func (c *Client) deleteAnswers(ctx context.Context, a *AnswerCache, filter bson.M) error {
+	filter, err := c.scopeFilter(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = a.store.DeleteMany(ctx, filter)
+	return err
+}
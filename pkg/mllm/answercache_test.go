@@ -0,0 +1,106 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAnswerCache(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	if err := c.SetAnswerCache(ctx, "answers"); err != nil {
+		t.Fatalf("SetAnswerCache: %v", err)
+	}
+
+	filename := writeFile(t, "doc.txt", "study_langchain 使用 MongoDB Atlas 保存向量。")
+	if _, err := c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+
+	srv.ReplyText("使用 MongoDB Atlas")
+	if res, err := c.Chain(ctx, "向量保存在哪里？"); err != nil || res["cached"] != nil {
+		t.Fatalf("Chain = %v, %v", res, err)
+	}
+
+	// 相同的问题直接返回缓存的回答
+	res, err := c.Chain(ctx, "向量保存在哪里？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	docs, _ := res["source_documents"].([]schema.Document)
+	if res["cached"] != true || res["text"] != "使用 MongoDB Atlas" || len(docs) < 1 || docs[0].Metadata[FilenameKey] != filename {
+		t.Fatalf("res = %+v", res)
+	}
+	if n := len(srv.RequestsTo("/api/chat")); n != 1 {
+		t.Fatalf("chat requests = %d", n)
+	}
+
+	// 不相似的问题不使用缓存
+	srv.ReplyText("不知道")
+	if res, err = c.Chain(ctx, "今天天气怎么样"); err != nil || res["cached"] != nil {
+		t.Fatalf("Chain = %v, %v", res, err)
+	}
+	if s := c.AnswerCacheStats(); s.Hits != 1 || s.Misses != 2 || s.Errors != 0 {
+		t.Fatalf("Stats = %+v", s)
+	}
+
+	// 文件更新后删除引用了该文件的缓存
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	c.mu.RLock()
+	answers := c.answers.store
+	c.mu.RUnlock()
+	if n, err := answers.Count(ctx, bson.M{"metadata." + SourceFilesKey: filename}); err != nil || n != 0 {
+		t.Fatalf("cached answers = %d, %v", n, err)
+	}
+
+	srv.ReplyText("仍然使用 MongoDB Atlas")
+	if res, err = c.Chain(ctx, "向量保存在哪里？"); err != nil || res["cached"] != nil || res["text"] != "仍然使用 MongoDB Atlas" {
+		t.Fatalf("Chain = %v, %v", res, err)
+	}
+}
+
+func TestAnswerCacheStaleSource(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	store := useMemoryStore(t, c, srv)
+	if err := c.SetAnswerCache(ctx, "answers"); err != nil {
+		t.Fatalf("SetAnswerCache: %v", err)
+	}
+
+	filename := writeFile(t, "doc.txt", "study_langchain 使用 MongoDB Atlas 保存向量。")
+	if _, err := c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	srv.ReplyText("使用 MongoDB Atlas", "重新生成的回答")
+	if _, err := c.Chain(ctx, "向量保存在哪里？"); err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+
+	// 绕过 AddDocuments 删除文件后，缓存在查询时失效
+	if _, err := store.DeleteMany(ctx, bson.M{"metadata." + FilenameKey: filename}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Chain(ctx, "向量保存在哪里？")
+	if err != nil || res["cached"] != nil || res["text"] != "重新生成的回答" {
+		t.Fatalf("Chain = %v, %v", res, err)
+	}
+}
+
+func TestSetAnswerCacheThreshold(t *testing.T) {
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	if err := c.SetAnswerCache(context.Background(), "answers", WithAnswerThreshold(1.5)); err == nil {
+		t.Fatal("阈值超出范围时应返回错误")
+	}
+}
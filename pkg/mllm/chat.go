@@ -0,0 +1,83 @@
+package mllm
+
+import (
+	"context"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"strings"
+)
+
+// RAGSystemPrompt 检索到的内容作为上下文时使用的系统提示词
+var RAGSystemPrompt = "使用以下内容回答用户的问题。如果内容中没有答案，请直接说不知道，不要编造答案。\n\n"
+
+// ChatResult 对话结果
+type ChatResult struct {
+	Content          string
+	Sources          []schema.Document // 检索到的分块
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Chat 携带历史消息进行对话，k 大于0时根据最后一条用户消息检索知识库，
+// 检索结果作为上下文添加到第一条系统消息中，没有系统消息时新增一条
+func (c *Client) Chat(ctx context.Context, messages []llms.MessageContent, k int, opts ...llms.CallOption) (*ChatResult, error) {
+	res := &ChatResult{}
+	if k > 0 {
+		query := lastHumanText(messages)
+		if query == "" {
+			return nil, fmt.Errorf("没有可用于检索的用户消息")
+		}
+
+		docs, err := c.Search(ctx, query, k)
+		if err != nil {
+			return nil, err
+		}
+		res.Sources = docs
+		messages = withContext(messages, docs)
+	}
+
+	resp, err := c.GenerateContent(ctx, messages, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) < 1 {
+		return nil, fmt.Errorf("模型返回空消息")
+	}
+
+	choice := resp.Choices[0]
+	res.Content = choice.Content
+	res.PromptTokens, _ = choice.GenerationInfo["PromptTokens"].(int)
+	res.CompletionTokens, _ = choice.GenerationInfo["CompletionTokens"].(int)
+	return res, nil
+}
+
+// lastHumanText 获取最后一条用户消息的文本内容
+func lastHumanText(messages []llms.MessageContent) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == llms.ChatMessageTypeHuman {
+			return partsText(messages[i].Parts)
+		}
+	}
+	return ""
+}
+
+func partsText(parts []llms.ContentPart) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		if t, ok := p.(llms.TextContent); ok {
+			sb.WriteString(t.Text)
+		}
+	}
+	return sb.String()
+}
+
+// withContext 将检索到的分块添加到系统消息中，不修改原消息
+func withContext(messages []llms.MessageContent, docs []schema.Document) []llms.MessageContent {
+	var sb strings.Builder
+	sb.WriteString(RAGSystemPrompt)
+	for i, doc := range docs {
+		fmt.Fprintf(&sb, "[%d] %s\n\n", i+1, doc.PageContent)
+	}
+	return withSystem(messages, sb.String())
+}
@@ -0,0 +1,274 @@
+package mllm
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/tmc/langchaingo/textsplitter"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ChineseSplitter 中文文本分割器
+// 按 。！？； 和换行拆分句子后再合并为分块，句末标点保留在句子末尾，
+// 单个句子超过分块大小时再按 ， 和空格进行拆分
+type ChineseSplitter struct {
+	ChunkSize    int
+	ChunkOverlap int
+	LenFunc      func(string) int
+	Prefetch     func([]string) // 拆分句子后批量计算句子长度，为 nil 时由 LenFunc 逐个计算
+
+	second textsplitter.TextSplitter // 处理超长句子
+}
+
+var _ textsplitter.TextSplitter = (*ChineseSplitter)(nil)
+
+// ChineseSeparators 超长句子使用的分隔符
+var ChineseSeparators = []string{"，", ",", "、", " ", ""}
+
+// NewChineseSplitter 创建中文文本分割器，支持 textsplitter.WithChunkSize、WithChunkOverlap、WithLenFunc
+// 配合 Client.TokenLenFunc 使用时，分块大小按模型 token 数计算
+func NewChineseSplitter(opts ...textsplitter.Option) *ChineseSplitter {
+	options := textsplitter.DefaultOptions()
+	options.ChunkSize, options.ChunkOverlap = 512, 128
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &ChineseSplitter{
+		ChunkSize:    options.ChunkSize,
+		ChunkOverlap: options.ChunkOverlap,
+		LenFunc:      options.LenFunc,
+		second: textsplitter.NewRecursiveCharacter(
+			textsplitter.WithSeparators(ChineseSeparators),
+			textsplitter.WithChunkSize(options.ChunkSize),
+			textsplitter.WithChunkOverlap(0),
+			textsplitter.WithLenFunc(options.LenFunc),
+		),
+	}
+}
+
+func (s *ChineseSplitter) SplitText(text string) ([]string, error) {
+	// 拆分句子，超长句子进行二次拆分
+	sentences := make([]string, 0, 32)
+	all := splitSentences(text)
+	if s.Prefetch != nil {
+		s.Prefetch(all)
+	}
+	for _, sentence := range all {
+		if s.LenFunc(sentence) <= s.ChunkSize {
+			sentences = append(sentences, sentence)
+			continue
+		}
+		parts, err := s.second.SplitText(sentence)
+		if err != nil {
+			return nil, err
+		}
+		sentences = append(sentences, parts...)
+	}
+
+	// 合并句子，相邻分块之间保留 ChunkOverlap 大小的句子
+	chunks := make([]string, 0, len(sentences)/8+1)
+	var (
+		current = make([]string, 0, 16)
+		size    int
+	)
+	for _, sentence := range sentences {
+		n := s.LenFunc(sentence)
+		if size > 0 && size+n > s.ChunkSize {
+			chunks = append(chunks, strings.Join(current, ""))
+
+			for size > s.ChunkOverlap || (size > 0 && size+n > s.ChunkSize) {
+				size -= s.LenFunc(current[0])
+				current = current[1:]
+			}
+		}
+		current = append(current, sentence)
+		size += n
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, strings.Join(current, ""))
+	}
+	return trimChunks(chunks), nil
+}
+
+// splitSentences 按句末标点和换行拆分句子，标点以及后面的引号、括号保留在句子末尾
+func splitSentences(text string) []string {
+	sentences := make([]string, 0, 16)
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '。', '！', '？', '；', '!', '?', ';', '\n':
+		case '.':
+			// 英文句号后需要跟空白，避免拆分小数和缩写
+			if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				continue
+			}
+		default:
+			continue
+		}
+
+		// 连续的标点作为同一个句子的结尾，例：！？ 或 。」
+		for i+1 < len(runes) && strings.ContainsRune(sentenceClosing, runes[i+1]) {
+			i++
+		}
+		if s := string(runes[start : i+1]); strings.TrimSpace(s) != "" {
+			sentences = append(sentences, s)
+		}
+		start = i + 1
+	}
+	if s := string(runes[start:]); strings.TrimSpace(s) != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// sentenceClosing 句末标点后可以跟随的字符
+const sentenceClosing = "。！？；!?;”’」』）)"
+
+// EstimateTokens 估算文本的 token 数，中文字符按1个 token 计算，其他字符按4个字符1个 token 计算
+// 无法访问模型分词器时作为 LenFunc 使用
+func EstimateTokens(text string) int {
+	var cjk, other int
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// UseChineseSplitter 使用中文分割器，分块大小按当前模型的 token 数计算，拆分句子后批量计算句子的 token 数
+func (c *Client) UseChineseSplitter(ctx context.Context, chunkSize, chunkOverlap int) {
+	counter := c.newTokenCounter(ctx)
+	s := NewChineseSplitter(
+		textsplitter.WithChunkSize(chunkSize),
+		textsplitter.WithChunkOverlap(chunkOverlap),
+		textsplitter.WithLenFunc(counter.count),
+	)
+	s.Prefetch = counter.prefetch
+	c.SetTextSplitter(s)
+}
+
+// TokenLenFunc 返回使用当前模型分词器计算 token 数的函数，可作为 textsplitter.WithLenFunc 的参数
+// 通过 ollama 的 /api/embed 接口获取 prompt_eval_count，最近的 TokenCacheSize 个结果会被缓存，请求失败时使用 EstimateTokens 估算
+func (c *Client) TokenLenFunc(ctx context.Context) func(string) int {
+	return c.newTokenCounter(ctx).count
+}
+
+var (
+	TokenCacheSize = 4096 // TokenLenFunc 缓存的文本数量
+	TokenBatchSize = 8    // 批量计算 token 数时同时发送的请求数量
+)
+
+// tokenCounter 计算 token 数，按 LRU 缓存结果
+type tokenCounter struct {
+	c   *Client
+	ctx context.Context
+
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type tokenEntry struct {
+	text string
+	n    int
+}
+
+func (c *Client) newTokenCounter(ctx context.Context) *tokenCounter {
+	return &tokenCounter{c: c, ctx: ctx, size: TokenCacheSize, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (t *tokenCounter) count(text string) int {
+	if text == "" {
+		return 0
+	}
+	if n, ok := t.get(text); ok {
+		return n
+	}
+
+	n, err := t.c.CountTokens(t.ctx, text)
+	if err != nil {
+		n = EstimateTokens(text)
+	}
+	t.set(text, n)
+	return n
+}
+
+// prefetch 计算 texts 中没有缓存的文本，ollama 只返回一次请求的 token 总数，
+// 所以每个文本单独请求，每批同时发送 TokenBatchSize 个请求
+func (t *tokenCounter) prefetch(texts []string) {
+	pending := make([]string, 0, len(texts))
+	seen := make(map[string]bool, len(texts))
+	for _, text := range texts {
+		if _, ok := t.get(text); !ok && text != "" && !seen[text] {
+			seen[text] = true
+			pending = append(pending, text)
+		}
+	}
+	// 超过缓存容量时先计算的结果会被淘汰，不预先计算
+	if len(pending) > t.size {
+		return
+	}
+
+	for batch := range slices.Chunk(pending, max(TokenBatchSize, 1)) {
+		var wg sync.WaitGroup
+		for _, text := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.count(text)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func (t *tokenCounter) get(text string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.items[text]
+	if !ok {
+		return 0, false
+	}
+	t.ll.MoveToFront(el)
+	return el.Value.(*tokenEntry).n, true
+}
+
+func (t *tokenCounter) set(text string, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.items[text]; ok {
+		el.Value.(*tokenEntry).n = n
+		t.ll.MoveToFront(el)
+		return
+	}
+	t.items[text] = t.ll.PushFront(&tokenEntry{text: text, n: n})
+	for t.ll.Len() > t.size {
+		last := t.ll.Back()
+		t.ll.Remove(last)
+		delete(t.items, last.Value.(*tokenEntry).text)
+	}
+}
+
+// CountTokens 使用当前模型分词器计算文本的 token 数
+func (c *Client) CountTokens(ctx context.Context, text string) (int, error) {
+	var res struct {
+		PromptEvalCount int `json:"prompt_eval_count"`
+	}
+	body := map[string]any{"model": c.model, "input": text, "truncate": false}
+	if err := c.postOllama(ctx, "/api/embed", body, &res); err != nil {
+		return 0, fmt.Errorf("获取token数失败：%w", err)
+	}
+	if res.PromptEvalCount < 1 && text != "" {
+		return 0, fmt.Errorf("模型 %s 未返回token数", c.model)
+	}
+	return res.PromptEvalCount, nil
+}
@@ -0,0 +1,66 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/textsplitter"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport 统计经过的请求数量
+type countingTransport struct {
+	n atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTokenLenFunc(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	tr := &countingTransport{}
+	if err := c.SetHTTPClient(&http.Client{Transport: tr}); err != nil {
+		t.Fatal(err)
+	}
+
+	size := TokenCacheSize
+	TokenCacheSize = 2
+	t.Cleanup(func() { TokenCacheSize = size })
+
+	lenFunc := c.TokenLenFunc(ctx)
+	for _, text := range []string{"向量", "检索", "检索", "模型", "向量"} {
+		if n := lenFunc(text); n != 2 {
+			t.Fatalf("lenFunc(%s) = %d", text, n)
+		}
+	}
+	// 缓存 2 个文本，第二次的 检索 命中缓存，计算 模型 时 向量 被淘汰
+	if n := len(srv.RequestsTo("/api/embed")); n != 4 {
+		t.Fatalf("embed requests = %d", n)
+	}
+	if tr.n.Load() != 4 {
+		t.Fatalf("没有使用设置的 http.Client：%d", tr.n.Load())
+	}
+}
+
+func TestChineseSplitterPrefetch(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	counter := c.newTokenCounter(ctx)
+	s := NewChineseSplitter(textsplitter.WithChunkSize(8), textsplitter.WithChunkOverlap(0), textsplitter.WithLenFunc(counter.count))
+	s.Prefetch = counter.prefetch
+
+	chunks, err := s.SplitText("第一句。第二句。第三句。第一句。")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %q", chunks)
+	}
+	// 预先计算了所有句子，合并时不再请求
+	if n := len(srv.RequestsTo("/api/embed")); n != 3 {
+		t.Fatalf("embed requests = %d", n)
+	}
+}
@@ -0,0 +1,376 @@
+package mllm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/tmc/langchaingo/embeddings"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// EmbeddingStore 向量缓存的一层，key 为 EmbeddingKey 生成的内容哈希
+type EmbeddingStore interface {
+	// Get 返回已缓存的向量，不存在的 key 不包含在结果中
+	Get(ctx context.Context, keys []string) (map[string][]float32, error)
+	Set(ctx context.Context, vectors map[string][]float32) error
+}
+
+// EmbeddingKey 缓存的 key，由模型名称和文本的 sha256 组成
+func EmbeddingKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheStats 向量缓存的命中统计
+type CacheStats struct {
+	Hits   int64 // 命中缓存的文本数量
+	Misses int64 // 调用模型生成向量的文本数量
+	Errors int64 // 读写缓存失败的次数，失败时直接调用模型
+}
+
+// HitRate 命中率
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type cacheCounters struct {
+	hits, misses, errors atomic.Int64
+}
+
+func (c *cacheCounters) stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+}
+
+// CachedEmbedder 带缓存的向量模型，按顺序查询各层缓存，下层命中时回填上层，都未命中时调用模型并写入所有层
+type CachedEmbedder struct {
+	emb      embeddings.Embedder
+	model    string
+	tiers    []EmbeddingStore
+	counters *cacheCounters
+}
+
+var _ embeddings.Embedder = (*CachedEmbedder)(nil)
+
+// NewCachedEmbedder 创建带缓存的向量模型，model 用于区分不同模型的缓存，需要包含模型版本(例：nomic-embed-text@0a109f422b47)，tiers 通常为 LRU 缓存加上磁盘或 mongodb 缓存
+func NewCachedEmbedder(emb embeddings.Embedder, model string, tiers ...EmbeddingStore) *CachedEmbedder {
+	return &CachedEmbedder{emb: emb, model: model, tiers: tiers, counters: &cacheCounters{}}
+}
+
+// Stats 命中统计
+func (e *CachedEmbedder) Stats() CacheStats {
+	return e.counters.stats()
+}
+
+func (e *CachedEmbedder) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := e.embed(ctx, []string{text}, func(ctx context.Context, texts []string) ([][]float32, error) {
+		vec, err := e.emb.EmbedQuery(ctx, texts[0])
+		return [][]float32{vec}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+func (e *CachedEmbedder) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return e.embed(ctx, texts, e.emb.EmbedDocuments)
+}
+
+func (e *CachedEmbedder) embed(ctx context.Context, texts []string, fn func(ctx context.Context, texts []string) ([][]float32, error)) ([][]float32, error) {
+	keys := make([]string, len(texts))
+	for i, text := range texts {
+		keys[i] = EmbeddingKey(e.model, text)
+	}
+
+	// 按层查询，下层命中的回填到上层
+	found := make(map[string][]float32, len(keys))
+	missing := uniqueKeys(keys)
+	for i, tier := range e.tiers {
+		if len(missing) < 1 {
+			break
+		}
+		res, err := tier.Get(ctx, missing)
+		if err != nil {
+			e.counters.errors.Add(1)
+			continue
+		}
+		if len(res) < 1 {
+			continue
+		}
+		if i > 0 {
+			e.setTiers(ctx, e.tiers[:i], res)
+		}
+		rest := missing[:0]
+		for _, k := range missing {
+			if v, ok := res[k]; ok {
+				found[k] = v
+			} else {
+				rest = append(rest, k)
+			}
+		}
+		missing = rest
+	}
+
+	// 未命中的文本调用模型，相同的文本只生成一次
+	var todo []string
+	pos := make(map[string]int)
+	for i, k := range keys {
+		if _, ok := found[k]; ok {
+			continue
+		}
+		if _, ok := pos[k]; !ok {
+			pos[k] = len(todo)
+			todo = append(todo, texts[i])
+		}
+	}
+	e.counters.hits.Add(int64(len(keys) - len(todo)))
+	e.counters.misses.Add(int64(len(todo)))
+
+	if len(todo) > 0 {
+		vecs, err := fn(ctx, todo)
+		if err != nil {
+			return nil, err
+		}
+		if len(vecs) != len(todo) {
+			return nil, errors.New("向量数量与文本数量不一致")
+		}
+		computed := make(map[string][]float32, len(todo))
+		for k, i := range pos {
+			computed[k], found[k] = vecs[i], vecs[i]
+		}
+		e.setTiers(ctx, e.tiers, computed)
+	}
+
+	res := make([][]float32, len(keys))
+	for i, k := range keys {
+		res[i] = found[k]
+	}
+	return res, nil
+}
+
+// setTiers 写入缓存，失败时只记录错误次数
+func (e *CachedEmbedder) setTiers(ctx context.Context, tiers []EmbeddingStore, vectors map[string][]float32) {
+	for _, tier := range tiers {
+		if err := tier.Set(ctx, vectors); err != nil {
+			e.counters.errors.Add(1)
+		}
+	}
+}
+
+func uniqueKeys(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// LRUCache 内存中的向量缓存，超过容量时淘汰最久未使用的向量
+type LRUCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	vec []float32
+}
+
+// NewLRUCache 创建最多缓存 size 个向量的 LRU 缓存
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *LRUCache) Get(_ context.Context, keys []string) (map[string][]float32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string][]float32)
+	for _, k := range keys {
+		if el, ok := c.items[k]; ok {
+			c.ll.MoveToFront(el)
+			res[k] = el.Value.(*lruEntry).vec
+		}
+	}
+	return res, nil
+}
+
+func (c *LRUCache) Set(_ context.Context, vectors map[string][]float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range vectors {
+		if el, ok := c.items[k]; ok {
+			el.Value.(*lruEntry).vec = v
+			c.ll.MoveToFront(el)
+			continue
+		}
+		c.items[k] = c.ll.PushFront(&lruEntry{key: k, vec: v})
+		for c.ll.Len() > c.size {
+			last := c.ll.Back()
+			c.ll.Remove(last)
+			delete(c.items, last.Value.(*lruEntry).key)
+		}
+	}
+	return nil
+}
+
+// Len 已缓存的向量数量
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// DiskCache 磁盘上的向量缓存，每个向量保存为一个文件：dir/key[:2]/key
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache 创建磁盘缓存，目录不存在时自动创建
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *DiskCache) Get(_ context.Context, keys []string) (map[string][]float32, error) {
+	res := make(map[string][]float32)
+	for _, k := range keys {
+		data, err := os.ReadFile(c.path(k))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data)%4 != 0 {
+			return nil, errors.New("向量缓存文件格式错误：" + c.path(k))
+		}
+		vec := make([]float32, len(data)/4)
+		for i := range vec {
+			vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+		}
+		res[k] = vec
+	}
+	return res, nil
+}
+
+func (c *DiskCache) Set(_ context.Context, vectors map[string][]float32) error {
+	for k, vec := range vectors {
+		data := make([]byte, len(vec)*4)
+		for i, v := range vec {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+		}
+
+		// 先写入临时文件再重命名，避免读到不完整的文件
+		filename := c.path(k)
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(filename), k+".*.tmp")
+		if err != nil {
+			return err
+		}
+		if _, err = tmp.Write(data); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err = tmp.Close(); err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err = os.Rename(tmp.Name(), filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type embeddingCache struct {
+	tiers    []EmbeddingStore
+	counters *cacheCounters
+}
+
+// SetEmbeddingCache 设置向量缓存，检索和添加文档时优先使用缓存中的向量，tiers 为空时不使用缓存
+func (c *Client) SetEmbeddingCache(tiers ...EmbeddingStore) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache, c.store = nil, nil
+	if len(tiers) > 0 {
+		c.cache = &embeddingCache{tiers: tiers, counters: &cacheCounters{}}
+	}
+}
+
+// reconnect 将使用 closing 中连接的 mongodb 缓存切换到 m 的连接，m 为 nil 时移除这些缓存，命中统计保持不变
+func (e *embeddingCache) reconnect(closing []Store, m *MongodbStore) *embeddingCache {
+	if e == nil {
+		return nil
+	}
+	tiers := make([]EmbeddingStore, 0, len(e.tiers))
+	for _, tier := range e.tiers {
+		mc, ok := tier.(*MongoEmbeddingCache)
+		if !ok || !slices.ContainsFunc(closing, mc.usesConn) {
+			tiers = append(tiers, tier)
+		} else if m != nil {
+			tiers = append(tiers, mc.withConn(m))
+		}
+	}
+	if len(tiers) < 1 {
+		return nil
+	}
+	return &embeddingCache{tiers: tiers, counters: e.counters}
+}
+
+// EmbeddingCacheStats 向量缓存的命中统计，没有设置缓存时都为0
+func (c *Client) EmbeddingCacheStats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cache == nil {
+		return CacheStats{}
+	}
+	return c.cache.counters.stats()
+}
+
+// cacheModel 向量缓存 key 中的模型，由模型名称和版本组成，同名模型重新拉取后不会命中之前的缓存
+func cacheModel(model, version string) string {
+	return model + "@" + version
+}
+
+// embeddingCacheModel 当前向量模型在缓存 key 中的名称，不能在持有 c.mu 时调用
+func (c *Client) embeddingCacheModel(ctx context.Context) (string, error) {
+	model, version, err := c.EmbeddingModel(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cacheModel(model, version), nil
+}
+
+// cachedEmbedder 设置了缓存时返回带缓存的向量模型，model 为 cacheModel 生成的名称，需要在持有 c.mu 时调用
+func (c *Client) cachedEmbedder(emb embeddings.Embedder, model string) embeddings.Embedder {
+	if c.cache == nil {
+		return emb
+	}
+	return &CachedEmbedder{emb: emb, model: model, tiers: c.cache.tiers, counters: c.cache.counters}
+}
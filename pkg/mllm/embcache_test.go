@@ -0,0 +1,146 @@
+package mllm
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCachedEmbedder(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lru := NewLRUCache(2)
+	e := NewCachedEmbedder(emb, "test-model", lru, disk)
+	vecs, err := e.EmbedDocuments(ctx, []string{"a", "b", "a"})
+	if err != nil {
+		t.Fatalf("EmbedDocuments: %v", err)
+	}
+	if len(vecs) != 3 || len(vecs[0]) != srv.Dimensions() || vecs[0][0] != vecs[2][0] {
+		t.Fatalf("vecs = %v", vecs)
+	}
+	if s := e.Stats(); s.Hits != 1 || s.Misses != 2 || len(srv.RequestsTo("/api/embeddings")) != 2 {
+		t.Fatalf("Stats = %+v, requests = %d", s, len(srv.RequestsTo("/api/embeddings")))
+	}
+
+	// 查询和文档使用同一个缓存
+	if _, err = e.EmbedQuery(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if s := e.Stats(); s.Hits != 2 || s.Misses != 2 {
+		t.Fatalf("Stats = %+v", s)
+	}
+
+	// 超过容量时淘汰最久未使用的
+	if _, err = e.EmbedQuery(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := lru.Get(ctx, []string{EmbeddingKey("test-model", "a")}); lru.Len() != 2 || len(got) != 0 {
+		t.Fatalf("LRU len = %d, a = %v", lru.Len(), got)
+	}
+
+	// 内存缓存为空时从磁盘读取并回填
+	e2 := NewCachedEmbedder(emb, "test-model", NewLRUCache(10), disk)
+	vecs2, err := e2.EmbedDocuments(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := e2.Stats(); s.Hits != 3 || s.Misses != 0 || vecs2[0][0] != vecs[0][0] {
+		t.Fatalf("Stats = %+v", s)
+	}
+
+	// 不同模型的缓存互不影响
+	e3 := NewCachedEmbedder(emb, "other-model", disk)
+	if _, err = e3.EmbedQuery(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if s := e3.Stats(); s.Misses != 1 || s.HitRate() != 0 {
+		t.Fatalf("Stats = %+v", s)
+	}
+}
+
+func TestClientEmbeddingCache(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	c.SetEmbeddingCache(NewLRUCache(100))
+
+	filename := writeFile(t, "doc.txt", "MongoDB Atlas 支持向量检索。")
+	if _, err := c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	before := len(srv.RequestsTo("/api/embeddings"))
+
+	// 文件修改时间变化但内容不变时不重新调用模型
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments again: %v", err)
+	}
+	if _, err := c.Search(ctx, "MongoDB Atlas 支持向量检索。", 1); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if n := len(srv.RequestsTo("/api/embeddings")); n != before {
+		t.Fatalf("embedding requests = %d, want %d", n, before)
+	}
+	if s := c.EmbeddingCacheStats(); s.Hits < 2 || s.Misses != int64(before) {
+		t.Fatalf("EmbeddingCacheStats = %+v", s)
+	}
+
+	// 切换向量模型后使用新的缓存 key
+	if err := c.SetEmbeddingModel("embed-v2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Search(ctx, "MongoDB Atlas 支持向量检索。", 1); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if n := len(srv.RequestsTo("/api/embeddings")); n != before+1 {
+		t.Fatalf("embedding requests = %d, want %d", n, before+1)
+	}
+}
+
+func TestEmbeddingCacheReconnect(t *testing.T) {
+	// mongo.Connect 不会立即建立连接，不需要 mongodb 服务
+	old, err := NewMongodbStore("mongodb://127.0.0.1:1", "study", "vector", "vector_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close(context.Background())
+	m, err := NewMongodbStore("mongodb://127.0.0.2:1", "other", "vector", "vector_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close(context.Background())
+	lru := NewLRUCache(10)
+	e := &embeddingCache{tiers: []EmbeddingStore{lru, old.EmbeddingCache("embedding_cache")}, counters: &cacheCounters{}}
+
+	// mongodb 缓存切换到新连接中同名数据库的同名集合
+	got := e.reconnect([]Store{old}, m)
+	mc, ok := got.tiers[1].(*MongoEmbeddingCache)
+	if len(got.tiers) != 2 || got.tiers[0] != lru || !ok || !mc.usesConn(m) || got.counters != e.counters {
+		t.Fatalf("reconnect = %+v", got)
+	}
+	if db, coll := mc.coll.Database().Name(), mc.coll.Name(); db != "study" || coll != "embedding_cache" {
+		t.Fatalf("collection = %s.%s", db, coll)
+	}
+
+	// 新的集合不是 mongodb 时移除
+	if got = e.reconnect([]Store{old}, nil); len(got.tiers) != 1 || got.tiers[0] != lru {
+		t.Fatalf("reconnect = %+v", got)
+	}
+	// 不使用关闭的连接时保持不变
+	if got = e.reconnect([]Store{m}, nil); len(got.tiers) != 2 || got.tiers[1] != e.tiers[1] {
+		t.Fatalf("reconnect = %+v", got)
+	}
+}
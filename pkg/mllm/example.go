@@ -0,0 +1,209 @@
+package mllm
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sort"
+	"strings"
+)
+
+var (
+	ExampleKey     = "example"      // 示例内容，json 格式
+	ExampleHashKey = "example_hash" // 示例内容的 hash，用于去重
+)
+
+// ExampleSelector 基于向量相似度的少样本示例选择器，通过 WithPromptSelector 使用
+// 示例保存在向量库中，每次根据输入选择最相似的 k 个示例，并且示例总长度不超过 maxTokens
+type ExampleSelector struct {
+	store       vectorstores.VectorStore
+	k           int
+	maxTokens   int              // 示例总长度上限，为0时不限制
+	lenFunc     func(string) int // 计算示例长度，默认 EstimateTokens
+	inputKeys   []string         // 输入中用于检索的变量，为空时使用所有变量
+	exampleKeys []string         // 示例中用于向量化的变量，为空时使用所有变量
+}
+
+var _ FewShotSelector = (*ExampleSelector)(nil)
+
+type ExampleOption func(*ExampleSelector)
+
+// WithExampleK 设置选择的示例数量
+func WithExampleK(k int) ExampleOption {
+	return func(s *ExampleSelector) {
+		s.k = k
+	}
+}
+
+// WithExampleMaxTokens 设置示例总长度上限
+func WithExampleMaxTokens(n int) ExampleOption {
+	return func(s *ExampleSelector) {
+		s.maxTokens = n
+	}
+}
+
+// WithExampleLenFunc 设置示例长度的计算方式，例：Client.TokenLenFunc
+func WithExampleLenFunc(f func(string) int) ExampleOption {
+	return func(s *ExampleSelector) {
+		s.lenFunc = f
+	}
+}
+
+// WithExampleInputKeys 设置输入中用于检索的变量
+func WithExampleInputKeys(keys ...string) ExampleOption {
+	return func(s *ExampleSelector) {
+		s.inputKeys = keys
+	}
+}
+
+// WithExampleKeys 设置示例中用于向量化的变量，一般为示例的输入部分
+func WithExampleKeys(keys ...string) ExampleOption {
+	return func(s *ExampleSelector) {
+		s.exampleKeys = keys
+	}
+}
+
+func NewExampleSelector(store vectorstores.VectorStore, opts ...ExampleOption) *ExampleSelector {
+	s := &ExampleSelector{
+		store:   store,
+		k:       4,
+		lenFunc: EstimateTokens,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// AddExamples 将示例保存到向量库
+func (s *ExampleSelector) AddExamples(ctx context.Context, examples ...map[string]string) ([]string, error) {
+	docs := make([]schema.Document, 0, len(examples))
+	for _, example := range examples {
+		doc, err := s.exampleDocument(example)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) < 1 {
+		return nil, nil
+	}
+	return s.store.AddDocuments(ctx, docs)
+}
+
+func (s *ExampleSelector) exampleDocument(example map[string]string) (schema.Document, error) {
+	data, err := json.Marshal(example) // map 按 key 排序，相同示例的 hash 一致
+	if err != nil {
+		return schema.Document{}, err
+	}
+	sum := sha1.Sum(data)
+	return schema.Document{
+		PageContent: joinValues(example, s.exampleKeys),
+		Metadata:    map[string]any{ExampleKey: string(data), ExampleHashKey: hex.EncodeToString(sum[:])},
+	}, nil
+}
+
+// Select 选择与输入最相似的示例，按相似度降序排列，超过长度上限的示例会被跳过
+func (s *ExampleSelector) Select(ctx context.Context, input map[string]string) ([]map[string]string, error) {
+	query := joinValues(input, s.inputKeys)
+	if query == "" {
+		return nil, errors.New("没有可用于检索的输入")
+	}
+
+	docs, err := s.store.SimilaritySearch(ctx, query, s.k)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res    = make([]map[string]string, 0, len(docs))
+		seen   = make(map[string]bool, len(docs))
+		tokens int
+	)
+	for _, doc := range docs {
+		data, _ := doc.Metadata[ExampleKey].(string)
+		if data == "" || seen[data] {
+			continue
+		}
+		seen[data] = true
+
+		example := make(map[string]string)
+		if err = json.Unmarshal([]byte(data), &example); err != nil {
+			return nil, err
+		}
+		if s.maxTokens > 0 {
+			n := s.lenFunc(joinValues(example, nil))
+			if tokens+n > s.maxTokens {
+				continue
+			}
+			tokens += n
+		}
+		res = append(res, example)
+	}
+	return res, nil
+}
+
+// joinValues 按 key 的顺序拼接变量值，keys 为空时按 key 排序拼接所有变量
+func joinValues(values map[string]string, keys []string) string {
+	if len(keys) < 1 {
+		keys = make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v := values[k]; v != "" {
+			res = append(res, v)
+		}
+	}
+	return strings.Join(res, "\n")
+}
+
+// NewExampleSelector 在当前数据库的 collname 集合中保存示例并创建选择器，
+// 集合和索引不存在时自动创建，已保存过的示例不会重复添加
+func (c *Client) NewExampleSelector(ctx context.Context, collname string, examples []map[string]string, opts ...ExampleOption) (*ExampleSelector, error) {
+	backend := c.getBackend()
+	if backend == nil {
+		return nil, errors.New("未设置 mongodb")
+	}
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		return nil, err
+	}
+
+	m := backend.Collection(collname)
+	if err = ensureStore(ctx, m); err != nil {
+		return nil, err
+	}
+
+	store := m.VectorStore(emb)
+	s := NewExampleSelector(store, opts...)
+
+	// 过滤已保存的示例
+	adds := make([]map[string]string, 0, len(examples))
+	for _, example := range examples {
+		doc, err := s.exampleDocument(example)
+		if err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx, bson.M{"metadata." + ExampleHashKey: doc.Metadata[ExampleHashKey]})
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			adds = append(adds, example)
+		}
+	}
+	if _, err = s.AddExamples(ctx, adds...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,45 @@
+package mllm
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExampleSelector(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	store := useMemoryStore(t, c, srv)
+
+	programming := map[string]string{"input": "I love programming", "output": "我爱编程"}
+	long := map[string]string{"input": "I love programming in Go and Rust every day", "output": "我每天都喜欢用 Go 和 Rust 编程"}
+	weather := map[string]string{"input": "weather", "output": "天气"}
+	examples := []map[string]string{weather, long, programming}
+
+	s, err := c.NewExampleSelector(ctx, "examples", examples, WithExampleK(3), WithExampleKeys("input"),
+		WithExampleLenFunc(utf8.RuneCountInString), WithExampleMaxTokens(40))
+	if err != nil {
+		t.Fatalf("NewExampleSelector: %v", err)
+	}
+	// 再次创建时不重复添加示例
+	if _, err = c.NewExampleSelector(ctx, "examples", examples); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.Collection("examples").Count(ctx, bson.M{}); err != nil || n != 3 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+
+	got, err := s.Select(ctx, map[string]string{"question": "I love programming"})
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	// 最相似的示例在前，超过长度上限的示例被跳过，之后较短的示例仍然可以加入
+	if len(got) != 2 || got[0]["input"] != programming["input"] || got[1]["input"] != weather["input"] {
+		t.Fatalf("Select = %v", got)
+	}
+
+	if _, err = s.Select(ctx, map[string]string{}); err == nil {
+		t.Fatal("没有输入时应返回错误")
+	}
+}
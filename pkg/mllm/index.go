@@ -0,0 +1,142 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// vectorSearch 索引的状态
+// https://www.mongodb.com/zh-cn/docs/atlas/atlas-search/manage-indexes/#index-statuses
+const (
+	IndexStatusDoesNotExist = "DOES_NOT_EXIST"
+	IndexStatusPending      = "PENDING"
+	IndexStatusBuilding     = "BUILDING"
+	IndexStatusReady        = "READY"
+	IndexStatusFailed       = "FAILED"
+	IndexStatusStale        = "STALE"
+	IndexStatusDeleting     = "DELETING"
+)
+
+// IndexStatus 索引状态
+type IndexStatus struct {
+	Name      string
+	Status    string
+	Queryable bool
+	Message   string // 索引创建失败的原因
+}
+
+// IndexWait 等待索引可以查询时的轮询配置，间隔从 Interval 开始按 Multiplier 倍增长，不超过 MaxInterval；
+// Interval 不大于0时使用 DefaultIndexWait.Interval
+type IndexWait struct {
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Multiplier  float64
+	Timeout     time.Duration // 为0时只受 ctx 限制
+}
+
+// DefaultIndexWait 默认的索引等待配置
+var DefaultIndexWait = IndexWait{
+	Interval:    time.Second,
+	MaxInterval: 30 * time.Second,
+	Multiplier:  2,
+	Timeout:     10 * time.Minute,
+}
+
+// IndexError 等待索引失败，Status 为最后一次查询到的索引状态，
+// Err 为 ctx 的错误、查询索引的错误或者 ErrIndexFailed
+type IndexError struct {
+	Index  string
+	Status IndexStatus
+	Err    error
+}
+
+// ErrIndexFailed 索引创建失败
+var ErrIndexFailed = errors.New("索引创建失败")
+
+func (e *IndexError) Error() string {
+	msg := fmt.Sprintf("等待索引 %s 失败(状态 %s)：%s", e.Index, e.Status.Status, e.Err.Error())
+	if e.Status.Message != "" {
+		msg += "，" + e.Status.Message
+	}
+	return msg
+}
+
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// IndexInfo 索引的状态及最新的定义
+type IndexInfo struct {
+	IndexStatus
+	Type   string  // vectorSearch 或 search
+	Fields []Field // 最新的索引字段，更新索引时为更新后的字段
+}
+
+// indexQueryable 索引可以查询
+func indexQueryable(s IndexStatus) bool {
+	return s.Queryable
+}
+
+// indexDropped 索引已删除
+func indexDropped(s IndexStatus) bool {
+	return s.Status == IndexStatusDoesNotExist
+}
+
+// waitIndex 轮询索引状态直到 done 返回 true，索引失败、超时或 ctx 取消时返回 *IndexError；
+// 使用 ctx 中的观测钩子记录 index_wait 阶段
+func waitIndex(ctx context.Context, w IndexWait, idx string, status func(ctx context.Context) (IndexStatus, error), done func(IndexStatus) bool) error {
+	ctx, s := startStage(ctx, StageIndexWait, slog.String(AttrIndex, idx))
+	err := pollIndex(ctx, w, idx, status, done)
+	s.end(err)
+	return err
+}
+
+func pollIndex(ctx context.Context, w IndexWait, idx string, status func(ctx context.Context) (IndexStatus, error), done func(IndexStatus) bool) error {
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+
+	last := IndexStatus{Name: idx, Status: IndexStatusDoesNotExist}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultIndexWait.Interval
+	}
+	for {
+		s, err := status(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return &IndexError{Index: idx, Status: last, Err: err}
+		}
+
+		// 刚创建的索引可能还查询不到，继续等待
+		last = s
+		if done(s) {
+			return nil
+		}
+		if s.Status == IndexStatusFailed {
+			return &IndexError{Index: idx, Status: s, Err: ErrIndexFailed}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &IndexError{Index: idx, Status: last, Err: ctx.Err()}
+		case <-timer.C:
+		}
+
+		if w.Multiplier > 1 {
+			interval = time.Duration(float64(interval) * w.Multiplier)
+		}
+		if w.MaxInterval > 0 && interval > w.MaxInterval {
+			interval = w.MaxInterval
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCreateIndexStatus(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("vector", "idx", WithQueryableAfter(3))
+	if err := store.CreateCollection(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []string
+	store.db.colls["vector"].indexes["idx"] = &memoryIndex{fields: store.Fields()}
+	err := waitIndex(ctx, IndexWait{Interval: time.Millisecond}, "idx", func(ctx context.Context) (IndexStatus, error) {
+		s, err := store.IndexStatus(ctx, "idx")
+		statuses = append(statuses, s.Status)
+		return s, err
+	}, indexQueryable)
+	if err != nil {
+		t.Fatalf("waitIndex: %v", err)
+	}
+	want := []string{IndexStatusPending, IndexStatusBuilding, IndexStatusReady}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+}
+
+func TestCreateIndexFailed(t *testing.T) {
+	store := NewMemoryStore("vector", "idx", WithIndexFailure("numDimensions 超出范围"))
+	err := store.CreateIndex(context.Background(), "idx", store.Fields())
+
+	var ie *IndexError
+	if !errors.As(err, &ie) || !errors.Is(err, ErrIndexFailed) {
+		t.Fatalf("CreateIndex error = %v", err)
+	}
+	if ie.Status.Status != IndexStatusFailed || ie.Status.Message == "" {
+		t.Fatalf("Status = %+v", ie.Status)
+	}
+}
+
+func TestCreateIndexTimeout(t *testing.T) {
+	wait := IndexWait{Interval: time.Millisecond, MaxInterval: 5 * time.Millisecond, Multiplier: 2, Timeout: 30 * time.Millisecond}
+	store := NewMemoryStore("vector", "idx", WithQueryableAfter(math.MaxInt), WithMemoryIndexWait(wait))
+	err := store.CreateIndex(context.Background(), "idx", store.Fields())
+
+	var ie *IndexError
+	if !errors.As(err, &ie) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("CreateIndex error = %v", err)
+	}
+	if ie.Status.Status != IndexStatusBuilding {
+		t.Fatalf("Status = %+v", ie.Status)
+	}
+}
+
+func TestWaitIndexZeroInterval(t *testing.T) {
+	var calls int
+	err := waitIndex(context.Background(), IndexWait{Timeout: 50 * time.Millisecond}, "idx", func(ctx context.Context) (IndexStatus, error) {
+		calls++
+		return IndexStatus{Name: "idx", Status: IndexStatusPending}, nil
+	}, indexQueryable)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+	// 间隔为0时使用默认间隔，超时前只查询一次
+	if calls != 1 {
+		t.Fatalf("calls = %d", calls)
+	}
+}
+
+func TestCreateIndexCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := IndexWait{Interval: time.Hour}
+	store := NewMemoryStore("vector", "idx", WithQueryableAfter(math.MaxInt), WithMemoryIndexWait(wait))
+
+	done := make(chan error, 1)
+	go func() { done <- store.CreateIndex(ctx, "idx", store.Fields()) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("CreateIndex error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("取消 ctx 后 CreateIndex 没有返回")
+	}
+}
+
+func TestUpdateIndex(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("vector", "idx", WithQueryableAfter(2))
+	if err := store.CreateIndex(ctx, "idx", store.Fields()); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := []Field{{Type: FieldTypeVector, Path: "embedding", NumDimensions: 8, Similarity: FieldSimilarityCosine}}
+	store.db.colls["vector"].indexes["idx"].latest = fields
+	store.db.colls["vector"].indexes["idx"].polls = 0
+
+	// 新的定义构建完成前旧的定义仍然可以查询
+	s, _ := store.IndexStatus(ctx, "idx")
+	if s.Status != IndexStatusPending || !s.Queryable {
+		t.Fatalf("更新中的索引状态 = %+v", s)
+	}
+	list, _ := store.ListIndexes(ctx)
+	if len(list) != 1 || list[0].Fields[0].NumDimensions != 8 {
+		t.Fatalf("ListIndexes = %+v", list)
+	}
+
+	fields[0].NumDimensions = 16
+	if err := store.UpdateIndex(ctx, "idx", fields); err != nil {
+		t.Fatalf("UpdateIndex: %v", err)
+	}
+	list, err := store.ListIndexes(ctx)
+	if err != nil || len(list) != 1 || list[0].Status != IndexStatusReady || list[0].Fields[0].NumDimensions != 16 {
+		t.Fatalf("ListIndexes = %+v, %v", list, err)
+	}
+
+	if err = store.UpdateIndex(ctx, "missing", fields); err == nil {
+		t.Fatal("更新不存在的索引应返回错误")
+	}
+}
+
+func TestListIndexes(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("vector", "b")
+	if list, err := store.ListIndexes(ctx); err != nil || len(list) != 0 {
+		t.Fatalf("集合不存在时 ListIndexes = %+v, %v", list, err)
+	}
+	for _, idx := range []string{"b", "a"} {
+		if err := store.CreateIndex(ctx, idx, store.Fields()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := store.ListIndexes(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "a" || list[1].Name != "b" {
+		t.Fatalf("ListIndexes = %+v, %v", list, err)
+	}
+	if list[0].Type != VectorSearchType || !list[0].Queryable || len(list[0].Fields) != len(store.Fields()) {
+		t.Fatalf("ListIndexes[0] = %+v", list[0])
+	}
+}
@@ -0,0 +1,211 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// KnowledgeBaseKey 检索结果元数据中的知识库名称，只在同时检索多个知识库时写入，不保存到集合
+var KnowledgeBaseKey = "knowledge_base"
+
+type knowledgeBasesKey struct{}
+
+// WithKnowledgeBases 返回指定了知识库的 ctx，检索和问答同时查询 names 中的所有知识库，
+// 添加、统计和删除等操作只能指定一个知识库；未指定时使用 SetStore 设置的集合
+func WithKnowledgeBases(ctx context.Context, names ...string) context.Context {
+	return context.WithValue(ctx, knowledgeBasesKey{}, slices.Clone(names))
+}
+
+// KnowledgeBasesFromContext 获取 ctx 中指定的知识库
+func KnowledgeBasesFromContext(ctx context.Context) []string {
+	names, _ := ctx.Value(knowledgeBasesKey{}).([]string)
+	return names
+}
+
+// AddKnowledgeBase 添加名为 name 的知识库，与当前集合共用 mongodb 连接，集合和索引不存在时自动创建。
+// collname 为空时使用 name，默认使用与当前集合相同的索引配置，可以通过 WithCollectionIndex 设置单独的索引
+func (c *Client) AddKnowledgeBase(ctx context.Context, name, collname string, opts ...CollectionOption) error {
+	if name == "" {
+		return errors.New("知识库名称不能为空")
+	}
+	if collname == "" {
+		collname = name
+	}
+	backend := c.getBackend()
+	if backend == nil {
+		return errors.New("未设置mongodb store")
+	}
+
+	store := backend.Collection(collname, opts...)
+	if err := ensureStore(c.observe(ctx), store); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bases == nil {
+		c.bases = make(map[string]Store)
+	}
+	c.bases[name] = store
+	return nil
+}
+
+// RemoveKnowledgeBase 移除知识库，不会删除集合中的数据
+func (c *Client) RemoveKnowledgeBase(name string) {
+	c.mu.Lock()
+	delete(c.bases, name)
+	c.mu.Unlock()
+}
+
+// KnowledgeBases 已添加的知识库名称，按名称排序
+func (c *Client) KnowledgeBases() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := slices.Collect(maps.Keys(c.bases))
+	sort.Strings(names)
+	return names
+}
+
+// knowledgeBase 名为 name 的知识库，name 为空时返回当前集合
+func (c *Client) knowledgeBase(name string) (Store, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if name == "" {
+		if c.backend == nil {
+			return nil, errors.New("未设置mongodb store")
+		}
+		return c.backend, nil
+	}
+	store, ok := c.bases[name]
+	if !ok {
+		return nil, fmt.Errorf("知识库 %s 不存在", name)
+	}
+	return store, nil
+}
+
+// backendFor ctx 中指定了一个知识库时返回该知识库，未指定时返回当前集合
+func (c *Client) backendFor(ctx context.Context) (Store, error) {
+	names := KnowledgeBasesFromContext(ctx)
+	switch len(names) {
+	case 0:
+		return c.knowledgeBase("")
+	case 1:
+		return c.knowledgeBase(names[0])
+	default:
+		return nil, fmt.Errorf("只能指定一个知识库：%s", strings.Join(names, ","))
+	}
+}
+
+// vectorStoreFor ctx 中指定了一个知识库时返回该知识库的向量库，未指定时同 GetStore
+func (c *Client) vectorStoreFor(ctx context.Context) (vectorstores.VectorStore, error) {
+	if len(KnowledgeBasesFromContext(ctx)) < 1 {
+		return c.GetStore()
+	}
+	store, err := c.backendFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model, err := c.embeddingCacheModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	emb, err := c.embedder()
+	if err != nil {
+		return nil, err
+	}
+	return store.VectorStore(c.cachedEmbedder(emb, model)), nil
+}
+
+// searchKnowledgeBases 在多个知识库中检索，问题只生成一次向量，按分数合并后返回前 k 个分块；
+// 不同相似度计算方式的分数无法比较，知识库的相似度不同时返回错误
+func (c *Client) searchKnowledgeBases(ctx context.Context, names []string, query string, k int, opts []vectorstores.Option) ([]schema.Document, error) {
+	stores := make([]Store, 0, len(names))
+	for _, name := range names {
+		store, err := c.knowledgeBase(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(stores) > 0 {
+			if a, b := vectorSimilarity(stores[0].Fields()), vectorSimilarity(store.Fields()); a != b {
+				return nil, fmt.Errorf("知识库 %s(%s) 与 %s(%s) 的相似度计算方式不同，无法合并检索结果", names[0], a, name, b)
+			}
+		}
+		stores = append(stores, store)
+	}
+	model, err := c.embeddingCacheModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	emb, err := c.embedder()
+	cached := c.cachedEmbedder(emb, model)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	vec, err := cached.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts[:len(opts):len(opts)], vectorstores.WithEmbedder(queryVector(vec)))
+	res := make([]schema.Document, 0, k*len(stores))
+	for i, store := range stores {
+		scoped, err := c.scopeSearch(ctx, opts, store)
+		if err != nil {
+			return nil, err
+		}
+		docs, err := store.VectorStore(cached).SimilaritySearch(ctx, query, k, scoped...)
+		if err != nil {
+			return nil, fmt.Errorf("检索知识库 %s 失败：%w", names[i], err)
+		}
+		for _, doc := range docs {
+			doc.Metadata = maps.Clone(doc.Metadata)
+			if doc.Metadata == nil {
+				doc.Metadata = make(map[string]any, 1)
+			}
+			doc.Metadata[KnowledgeBaseKey] = names[i]
+			res = append(res, doc)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+	return res[:min(k, len(res))], nil
+}
+
+// queryVector 返回已生成的问题向量，避免每个知识库重复生成
+type queryVector []float32
+
+var _ embeddings.Embedder = queryVector(nil)
+
+func (v queryVector) EmbedDocuments(context.Context, []string) ([][]float32, error) {
+	return nil, errors.New("queryVector 只用于检索")
+}
+
+func (v queryVector) EmbedQuery(context.Context, string) ([]float32, error) {
+	return v, nil
+}
+
+// retriever 通过 Search 检索的 Retriever，检索时使用 ctx 中的租户和知识库
+type retriever struct {
+	client *Client
+	k      int
+}
+
+var _ schema.Retriever = retriever{}
+
+func (r retriever) GetRelevantDocuments(ctx context.Context, query string) ([]schema.Document, error) {
+	return r.client.Search(ctx, query, r.k)
+}
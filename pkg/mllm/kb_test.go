@@ -0,0 +1,119 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/schema"
+	"strings"
+	"testing"
+)
+
+func TestKnowledgeBases(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	store := useMemoryStore(t, c, srv)
+
+	field := Field{Type: FieldTypeVector, Path: "vec", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	if err := c.AddKnowledgeBase(ctx, "product-docs", "product_docs"); err != nil {
+		t.Fatalf("AddKnowledgeBase: %v", err)
+	}
+	if err := c.AddKnowledgeBase(ctx, "runbooks", "", WithCollectionIndex("runbooks_index", field)); err != nil {
+		t.Fatalf("AddKnowledgeBase: %v", err)
+	}
+	if got := c.KnowledgeBases(); len(got) != 2 || got[0] != "product-docs" || got[1] != "runbooks" {
+		t.Fatalf("KnowledgeBases = %v", got)
+	}
+
+	docs := WithKnowledgeBases(ctx, "product-docs")
+	runbooks := WithKnowledgeBases(ctx, "runbooks")
+	both := WithKnowledgeBases(ctx, "product-docs", "runbooks")
+	if _, err := c.AddDocuments(docs, writeFile(t, "product.txt", "产品使用 MongoDB Atlas 保存向量。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if _, err := c.AddDocuments(runbooks, writeFile(t, "runbook.txt", "MongoDB 故障时重启 Atlas 服务。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if n := countDocs(t, store); n != 0 {
+		t.Fatalf("默认集合 chunks = %d", n)
+	}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want int
+	}{{docs, 1}, {runbooks, 1}, {both, 2}} {
+		sources, err := c.Search(tc.ctx, "MongoDB Atlas", 4)
+		if err != nil {
+			t.Fatalf("Search: %v", err)
+		}
+		if len(sources) != tc.want {
+			t.Fatalf("Search = %+v", sources)
+		}
+	}
+
+	// 同时检索时按分数合并，并标记所在的知识库
+	res, err := c.Search(both, "MongoDB Atlas", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Score < res[1].Score || res[0].Metadata[KnowledgeBaseKey] == res[1].Metadata[KnowledgeBaseKey] {
+		t.Fatalf("Search = %+v", res)
+	}
+	neighbors, err := c.Neighbors(ctx, res[0], 1)
+	if err != nil || len(neighbors) != 1 {
+		t.Fatalf("Neighbors = %+v, %v", neighbors, err)
+	}
+
+	sources, err := c.ListSources(runbooks)
+	if err != nil || len(sources) != 1 || sources[0].Chunks != 1 {
+		t.Fatalf("ListSources = %+v, %v", sources, err)
+	}
+	if _, err = c.ListSources(both); err == nil {
+		t.Fatal("指定多个知识库时 ListSources 应返回错误")
+	}
+	if _, err = c.Search(WithKnowledgeBases(ctx, "missing"), "MongoDB", 4); err == nil {
+		t.Fatal("知识库不存在时应返回错误")
+	}
+
+	srv.ReplyText("重启 Atlas 服务")
+	answer, err := c.Chain(both, "MongoDB 故障怎么办？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if refs, _ := answer["source_documents"].([]schema.Document); len(refs) != 2 {
+		t.Fatalf("source_documents = %+v", answer["source_documents"])
+	}
+
+	c.RemoveKnowledgeBase("runbooks")
+	if _, err = c.Search(both, "MongoDB", 4); err == nil {
+		t.Fatal("移除后检索应返回错误")
+	}
+}
+
+func TestKnowledgeBasesSimilarity(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityEuclidean}
+	if err := c.AddKnowledgeBase(ctx, "cosine", "cosine"); err != nil {
+		t.Fatalf("AddKnowledgeBase: %v", err)
+	}
+	if err := c.AddKnowledgeBase(ctx, "euclidean", "euclidean", WithCollectionIndex("euclidean_index", field)); err != nil {
+		t.Fatalf("AddKnowledgeBase: %v", err)
+	}
+	for _, name := range []string{"cosine", "euclidean"} {
+		if _, err := c.AddDocuments(WithKnowledgeBases(ctx, name), writeFile(t, name+".txt", "MongoDB Atlas 保存向量。")); err != nil {
+			t.Fatalf("AddDocuments: %v", err)
+		}
+	}
+
+	// 单独检索不受影响
+	for _, name := range []string{"cosine", "euclidean"} {
+		if res, err := c.Search(WithKnowledgeBases(ctx, name), "MongoDB Atlas", 4); err != nil || len(res) != 1 {
+			t.Fatalf("Search(%s) = %+v, %v", name, res, err)
+		}
+	}
+	// 余弦和欧氏距离的分数无法比较，不能合并
+	if _, err := c.Search(WithKnowledgeBases(ctx, "cosine", "euclidean"), "MongoDB Atlas", 4); err == nil || !strings.Contains(err.Error(), "相似度") {
+		t.Fatalf("Search err = %v", err)
+	}
+}
@@ -1,22 +1,49 @@
 package mllm
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/tmc/langchaingo/chains"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms/ollama"
 	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
 	"github.com/tmc/langchaingo/vectorstores"
-	"github.com/tmc/langchaingo/vectorstores/mongovector"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
 )
 
 type Client struct {
 	*ollama.LLM
-	model string
-	mongo *MongodbStore
-	store *mongovector.Store
-	emb   *embeddings.EmbedderImpl
+	model   string
+	url     string
+	opts    []ollama.Option  // 创建 LLM 的参数，切换向量模型时复用
+	hc      *http.Client     // 直接调用 ollama 接口使用的客户端，为 nil 时使用 http.DefaultClient
+	mu      sync.RWMutex     // 保护 backend、store、emb、embInfo、cache、answers、conn、bases 和 observers，迁移时切换集合
+	writeMu sync.RWMutex     // 写入或删除分块期间持有读锁，Migrate 同步和切换集合时持有写锁
+	backend Store            // 保存分块的集合
+	conn    *MongodbStore    // SetMongodbStore 创建的连接，backend 和知识库共用
+	connURI string           // conn 的地址，地址相同时复用连接
+	bases   map[string]Store // 通过 AddKnowledgeBase 添加的知识库
+	store   vectorstores.VectorStore
+	emb     *embeddings.EmbedderImpl
+	embInfo embeddingInfo   // 向量模型名称和版本
+	cache   *embeddingCache // 向量缓存，为 nil 时不使用缓存
+	answers *AnswerCache    // Chain 的语义缓存，为 nil 时不使用缓存
+
+	tenantRequired bool       // 访问知识库时必须指定租户
+	observers      []Observer // 观测各阶段的钩子
+
+	spliter textsplitter.TextSplitter // 自定义文本分割器
+	prompts *PromptRegistry           // 提示词模板库
 }
 
 func NewLLM(model, uri string, opts ...ollama.Option) (*Client, error) {
@@ -26,77 +53,155 @@ func NewLLM(model, uri string, opts ...ollama.Option) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{LLM: llm, model: model}, nil
+	return &Client{LLM: llm, model: model, url: uri, opts: opts}, nil
+}
+
+// SetHTTPClient 设置请求 ollama 使用的 http.Client，对话、向量化和 CountTokens 等直接调用的接口都使用该客户端；
+// 需要在使用 Client 之前调用
+func (c *Client) SetHTTPClient(hc *http.Client) error {
+	opts := append(slices.Clone(c.opts), ollama.WithHTTPClient(hc))
+	llm, err := ollama.New(opts...)
+	if err != nil {
+		return err
+	}
+	c.LLM, c.opts, c.hc = llm, opts, hc
+	return nil
 }
 
 func (c *Client) GetModel() string {
 	return c.model
 }
 
-func (c *Client) SetMongodbStore(ctx context.Context, uri, dbname, collname, idx string, fields ...Field) (err error) {
-	c.mongo, err = NewMongodbStore(uri, dbname, collname, idx)
-	if err != nil {
-		return err
+// SetMongodbStore 连接 mongodb 并设置保存分块的集合，fields 为空时使用默认的向量字段。
+// 地址与上次相同时复用连接；地址不同时切换后关闭之前的连接，之前添加的知识库和语义缓存会被移除
+func (c *Client) SetMongodbStore(ctx context.Context, uri, dbname, collname, idx string, fields ...Field) error {
+	if len(fields) < 1 {
+		fields = defaultFields()
 	}
+	c.mu.RLock()
+	conn, connURI := c.conn, c.connURI
+	c.mu.RUnlock()
 
-	// 创建集合
-	if !c.mongo.SelectCollection(ctx) {
-		if err = c.mongo.CreateCollection(ctx); err != nil {
-			return
+	var (
+		m   *MongodbStore
+		err error
+	)
+	reuse := conn != nil && connURI == uri
+	if reuse {
+		m = conn.Database(dbname, collname, WithCollectionIndex(idx, fields...))
+	} else if m, err = NewMongodbStore(uri, dbname, collname, idx, fields...); err != nil {
+		return err
+	}
+	if err = ensureStore(c.observe(ctx), m); err != nil {
+		if !reuse {
+			m.Close(ctx)
 		}
+		return err
 	}
 
-	// 创建索引
-	if flag, _ := c.mongo.SelectIndex(ctx, idx); !flag {
-		if len(fields) < 1 {
-			fields = append(fields, Field{
-				Type:          FieldTypeVector,
-				Path:          "plot_embedding",
-				NumDimensions: 2048,
-				Similarity:    FieldSimilarityDotProduct,
-			})
-		}
-		return c.mongo.CreateIndex(ctx, idx, fields)
+	c.mu.Lock()
+	closing := c.replaceBackend(m)
+	if !reuse {
+		c.conn, c.connURI = m, uri
 	}
-	return nil
+	c.mu.Unlock()
+	return closeStores(ctx, closing)
 }
 
-func (c *Client) GetStore() (*mongovector.Store, error) {
+// GetStore 获取向量库，没有通过 SetVectorStore 设置时使用 mongodb
+func (c *Client) GetStore() (vectorstores.VectorStore, error) {
+	c.mu.RLock()
+	store := c.store
+	c.mu.RUnlock()
+	if store != nil {
+		return store, nil
+	}
+	model, err := c.embeddingCacheModel(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.store != nil {
 		return c.store, nil
 	}
+	if c.backend == nil {
+		return nil, errors.New("未设置向量库")
+	}
 
-	var err error
-	c.emb, err = embeddings.NewEmbedder(c.LLM)
+	emb, err := c.embedder()
 	if err != nil {
 		return nil, err
 	}
-
-	// 获取到mongodbVector
-	store := mongovector.New(c.mongo.coll, c.emb, mongovector.WithIndex(c.mongo.idx))
-	c.store = &store
+	c.store = c.backend.VectorStore(c.cachedEmbedder(emb, model))
 	return c.store, nil
 }
 
+// SetVectorStore 设置向量库，替代 mongodb，例：测试时使用内存向量库
+func (c *Client) SetVectorStore(store vectorstores.VectorStore) {
+	c.mu.Lock()
+	c.store = store
+	c.mu.Unlock()
+}
+
+// GetEmbedder 获取向量化使用的 embedder
+func (c *Client) GetEmbedder() (*embeddings.EmbedderImpl, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.embedder()
+}
+
+// embedder 需要在持有 c.mu 时调用
+func (c *Client) embedder() (*embeddings.EmbedderImpl, error) {
+	if c.emb != nil {
+		return c.emb, nil
+	}
+
+	var err error
+	c.emb, err = embeddings.NewEmbedder(embedClient{EmbedderClient: c.LLM, c: c})
+	return c.emb, err
+}
+
+// AddDocuments 加载文件并写入向量库，跳过没有更新的文件，文件更新时写入成功后删除旧版本的分块
 func (c *Client) AddDocuments(ctx context.Context, filename string) ([]string, error) {
+	if _, err := c.tenantFilter(ctx); err != nil {
+		return nil, err
+	}
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	tenant, _ := TenantFromContext(ctx)
 	docs, files, err := c.load(ctx, filename)
 	if err != nil {
 		return nil, err
 	}
 
-	// 获取表中所有数据
-	list := make([]Vector, 0, len(files))
-	cursor, err := c.mongo.coll.Find(ctx, bson.M{"metadata.filename": bson.M{"$in": files}})
-	if err != nil {
-		return nil, err
+	backend := c.getBackend()
+	if len(KnowledgeBasesFromContext(ctx)) > 0 {
+		if backend, err = c.backendFor(ctx); err != nil {
+			return nil, err
+		}
 	}
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, err
+
+	if tenant != "" && backend != nil && !hasTenantField(backend) {
+		return nil, fmt.Errorf("使用租户时索引 %s 中需要包含 %s 过滤字段", backend.Index(), TenantField().Path)
 	}
+
+	// 获取表中当前租户的数据，没有使用 mongodb 时不去重
 	fileExistsMap := make(map[string]string)
-	for _, doc := range list {
-		k := doc.Metadata[FilenameKey].(string)
-		fileExistsMap[k] = doc.Metadata[UpdatedTime].(string)
+	if backend != nil {
+		filter, err := c.scopeFilter(ctx, bson.M{"metadata." + FilenameKey: bson.M{"$in": files}})
+		if err != nil {
+			return nil, err
+		}
+		list, err := backend.Find(ctx, filter, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range list {
+			k := doc.Metadata[FilenameKey].(string)
+			fileExistsMap[k] = doc.Metadata[UpdatedTime].(string)
+		}
 	}
 
 	// 去除重复文档数据
@@ -105,6 +210,9 @@ func (c *Client) AddDocuments(ctx context.Context, filename string) ([]string, e
 		key := doc.Metadata[FilenameKey].(string)
 		val := doc.Metadata[UpdatedTime].(string)
 		if fileExistsMap[key] != val {
+			if tenant != "" {
+				doc.Metadata[TenantKey] = tenant
+			}
 			newdocs = append(newdocs, doc)
 		}
 	}
@@ -112,25 +220,199 @@ func (c *Client) AddDocuments(ctx context.Context, filename string) ([]string, e
 		return []string{}, nil
 	}
 
-	store, err := c.GetStore()
+	store, err := c.vectorStoreFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model, version, err := c.EmbeddingModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	setEmbeddingMetadata(newdocs, model, version)
+	sctx, s := c.startStage(ctx, StageStore, slog.Int(AttrChunks, len(newdocs)))
+	ids, err := store.AddDocuments(sctx, newdocs)
+	s.end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// 新版本写入成功后再删除文件旧版本的分块，写入失败时旧分块仍然可以检索
+	updated := make([]string, 0, len(files))
+	for _, doc := range newdocs {
+		f := doc.Metadata[FilenameKey].(string)
+		if slices.Contains(updated, f) {
+			continue
+		}
+		updated = append(updated, f)
+		if _, ok := fileExistsMap[f]; !ok || backend == nil {
+			continue
+		}
+		filter, err := c.scopeFilter(ctx, bson.M{
+			"metadata." + FilenameKey: f,
+			"metadata." + UpdatedTime: bson.M{"$ne": doc.Metadata[UpdatedTime]},
+		})
+		if err != nil {
+			return ids, err
+		}
+		if _, err = backend.DeleteMany(ctx, filter); err != nil {
+			return ids, err
+		}
+	}
+
+	// 删除引用了已更新文件的缓存回答
+	if err = c.invalidateAnswers(ctx, updated); err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// Neighbors 获取分块前后 window 个相邻分块（包含自身），按分块序号排序；
+// doc 来自多个知识库的检索结果时从 doc 所在的知识库中查询
+func (c *Client) Neighbors(ctx context.Context, doc schema.Document, window int) ([]schema.Document, error) {
+	var (
+		backend Store
+		err     error
+	)
+	if name, _ := doc.Metadata[KnowledgeBaseKey].(string); name != "" {
+		backend, err = c.knowledgeBase(name)
+	} else {
+		backend, err = c.backendFor(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	filename, _ := doc.Metadata[FilenameKey].(string)
+	idx, ok := toInt(doc.Metadata[ChunkIndexKey])
+	if filename == "" || !ok {
+		return nil, errors.New("文档缺少分块位置信息")
+	}
+
+	filter, err := c.scopeFilter(ctx, bson.M{
+		"metadata." + FilenameKey:   filename,
+		"metadata." + ChunkIndexKey: bson.M{"$gte": idx - window, "$lte": idx + window},
+	})
 	if err != nil {
 		return nil, err
 	}
-	return store.AddDocuments(ctx, newdocs)
+	list, err := backend.Find(ctx, filter, "metadata."+ChunkIndexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]schema.Document, 0, len(list))
+	for _, v := range list {
+		docs = append(docs, schema.Document{PageContent: v.PageContent, Metadata: v.Metadata})
+	}
+	return docs, nil
 }
 
+// Close 关闭当前集合和 SetMongodbStore 创建的连接
 func (c *Client) Close(ctx context.Context) (err error) {
-	if c.mongo != nil {
-		err = c.mongo.Close(ctx)
+	c.mu.RLock()
+	backend, conn := c.backend, c.conn
+	c.mu.RUnlock()
+	if backend != nil {
+		err = backend.Close(ctx)
+	}
+	if conn != nil && !sameConn(conn, backend) {
+		err = errors.Join(err, conn.Close(ctx))
 	}
 	return err
 }
 
-func (c *Client) Chain(ctx context.Context, query string) (map[string]any, error) {
-	store, err := c.GetStore()
+// Search 在向量库中查询与 query 最相似的 k 个分块，ctx 中有租户时只查询该租户的分块，
+// 指定了多个知识库时在所有知识库中检索，结果元数据中的 knowledge_base 为所在的知识库
+func (c *Client) Search(ctx context.Context, query string, k int, opts ...vectorstores.Option) ([]schema.Document, error) {
+	if _, err := c.tenantFilter(ctx); err != nil {
+		return nil, err
+	}
+	ctx, s := c.startStage(ctx, StageRetrieve)
+	docs, err := c.search(ctx, query, k, opts)
+	s.end(err, slog.Int(AttrDocuments, len(docs)))
+	return docs, err
+}
+
+// search 按 ctx 中的知识库和租户检索
+func (c *Client) search(ctx context.Context, query string, k int, opts []vectorstores.Option) ([]schema.Document, error) {
+	names := KnowledgeBasesFromContext(ctx)
+	if len(names) > 1 {
+		return c.searchKnowledgeBases(ctx, names, query, k, opts)
+	}
+
+	var (
+		backend = c.getBackend()
+		err     error
+	)
+	if len(names) > 0 {
+		if backend, err = c.backendFor(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if opts, err = c.scopeSearch(ctx, opts, backend); err != nil {
+		return nil, err
+	}
+	store, err := c.vectorStoreFor(ctx)
 	if err != nil {
 		return nil, err
 	}
-	qa := chains.NewRetrievalQAFromLLM(c.LLM, vectorstores.ToRetriever(store, 10))
-	return qa.Call(ctx, map[string]interface{}{"query": query})
+	return store.SimilaritySearch(ctx, query, k, opts...)
+}
+
+// Chain 基于向量库回答问题，返回结果中 text 为回答内容，source_documents 为引用的分块；
+// 设置了 SetAnswerCache 时优先返回缓存的回答，此时结果中 cached 为 true；检索范围与 Search 相同
+func (c *Client) Chain(ctx context.Context, query string, opts ...chains.ChainCallOption) (map[string]any, error) {
+	if _, err := c.tenantFilter(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	answers := c.answers
+	c.mu.RUnlock()
+	if answers != nil {
+		if res, ok := c.lookupAnswer(ctx, answers, query); ok {
+			return res, nil
+		}
+	}
+
+	qa := chains.NewRetrievalQAFromLLM(c, retriever{client: c, k: 10})
+	qa.ReturnSourceDocuments = true
+	res, err := qa.Call(ctx, map[string]interface{}{"query": query}, opts...)
+	if err == nil && answers != nil {
+		c.saveAnswer(ctx, answers, query, res)
+	}
+	return res, err
+}
+
+// postOllama 直接调用 ollama 接口，用于 langchaingo 没有支持的功能
+func (c *Client) postOllama(ctx context.Context, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.requestOllama(ctx, http.MethodPost, path, bytes.NewReader(data), out)
+}
+
+// requestOllama 请求 ollama 接口并解析返回的 json，body 为 nil 时不发送请求体
+func (c *Client) requestOllama(ctx context.Context, method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.url, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	hc := c.hc
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("请求 %s 失败：%s %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
 }
@@ -0,0 +1,187 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"os"
+	"path/filepath"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+)
+
+func newTestClient(t *testing.T) (*Client, *ollamatest.Server) {
+	t.Helper()
+	srv := ollamatest.NewServer()
+	t.Cleanup(srv.Close)
+
+	c, err := NewLLM("test-model", srv.URL)
+	if err != nil {
+		t.Fatalf("NewLLM: %v", err)
+	}
+	return c, srv
+}
+
+// useMemoryStore 使用内存集合，向量维度与模拟服务一致
+func useMemoryStore(t *testing.T, c *Client, srv *ollamatest.Server, opts ...MemoryOption) *MemoryStore {
+	t.Helper()
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	store := NewMemoryStore("vector", "vector_index", append([]MemoryOption{WithMemoryFields(field)}, opts...)...)
+	if err := c.SetStore(context.Background(), store); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+	return store
+}
+
+func countDocs(t *testing.T, store *MemoryStore) int {
+	t.Helper()
+	n, err := store.Count(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatalf("Count: %v", err)
+	}
+	return int(n)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestNewLLM(t *testing.T) {
+	c, srv := newTestClient(t)
+	if c.GetModel() != "test-model" {
+		t.Fatalf("GetModel = %q", c.GetModel())
+	}
+
+	srv.ReplyText("你好")
+	res, err := c.LLM.Call(context.Background(), "hello")
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if res != "你好" {
+		t.Fatalf("Call = %q, want 你好", res)
+	}
+
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) != 1 || reqs[0].Model != "test-model" || reqs[0].Messages[0].Content != "hello" {
+		t.Fatalf("unexpected requests: %+v", reqs)
+	}
+}
+
+func TestNewLLMStreaming(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.ReplyText("one two three")
+
+	var chunks []string
+	resp, err := c.LLM.GenerateContent(context.Background(),
+		[]llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "count")},
+		llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+			chunks = append(chunks, string(chunk))
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	if len(chunks) < 3 || strings.Join(chunks, "") != "one two three" {
+		t.Fatalf("chunks = %q", chunks)
+	}
+	if resp.Choices[0].Content != "one two three" {
+		t.Fatalf("content = %q", resp.Choices[0].Content)
+	}
+	if tokens, _ := resp.Choices[0].GenerationInfo["PromptTokens"].(int); tokens != 1 {
+		t.Fatalf("PromptTokens = %d, want 1", tokens)
+	}
+}
+
+func TestNewLLMError(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.Reply(ollamatest.Response{Status: 500, Error: "model not found"})
+
+	if _, err := c.LLM.Call(context.Background(), "hello"); err == nil || !strings.Contains(err.Error(), "model not found") {
+		t.Fatalf("Call error = %v", err)
+	}
+}
+
+func TestAddDocuments(t *testing.T) {
+	c, srv := newTestClient(t)
+	store := useMemoryStore(t, c, srv)
+
+	filename := writeFile(t, "doc.txt", "MongoDB Atlas 支持向量检索。\n\nOllama 可以在本地运行大模型。")
+	ids, err := c.AddDocuments(context.Background(), filename)
+	if err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	docs, err := store.Find(context.Background(), bson.M{}, "metadata."+ChunkIndexKey)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(ids) < 1 || len(ids) != len(docs) {
+		t.Fatalf("ids = %v, docs = %d", ids, len(docs))
+	}
+
+	for i, doc := range docs {
+		if doc.Metadata[FilenameKey] != filename {
+			t.Errorf("docs[%d] filename = %v", i, doc.Metadata[FilenameKey])
+		}
+		if doc.Metadata[UpdatedTime] == "" {
+			t.Errorf("docs[%d] 缺少 %s", i, UpdatedTime)
+		}
+		if idx, ok := toInt(doc.Metadata[ChunkIndexKey]); !ok || idx != i {
+			t.Errorf("docs[%d] chunk_index = %v", i, doc.Metadata[ChunkIndexKey])
+		}
+	}
+	if len(srv.RequestsTo("/api/embeddings")) != len(docs) {
+		t.Fatalf("embedding requests = %d, want %d", len(srv.RequestsTo("/api/embeddings")), len(docs))
+	}
+}
+
+func TestAddDocumentsMissingFile(t *testing.T) {
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+
+	if _, err := c.AddDocuments(context.Background(), filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Fatal("AddDocuments 应返回文件不存在的错误")
+	}
+}
+
+func TestChain(t *testing.T) {
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+
+	filename := writeFile(t, "doc.txt", "study_langchain 使用 MongoDB Atlas 保存向量。")
+	if _, err := c.AddDocuments(context.Background(), filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+
+	srv.ReplyText("使用 MongoDB Atlas")
+	res, err := c.Chain(context.Background(), "向量保存在哪里？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if res["text"] != "使用 MongoDB Atlas" {
+		t.Fatalf("text = %v", res["text"])
+	}
+	docs, _ := res["source_documents"].([]schema.Document)
+	if len(docs) < 1 || docs[0].Metadata[FilenameKey] != filename {
+		t.Fatalf("source_documents = %+v", res["source_documents"])
+	}
+
+	// 检索到的分块作为上下文发送给模型
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) != 1 || !strings.Contains(reqs[0].Messages[0].Content, "study_langchain 使用 MongoDB Atlas 保存向量") {
+		t.Fatalf("chat requests = %+v", reqs)
+	}
+}
+
+func TestChainWithoutStore(t *testing.T) {
+	c, _ := newTestClient(t)
+	if _, err := c.Chain(context.Background(), "question"); err == nil {
+		t.Fatal("没有向量库时 Chain 应返回错误")
+	}
+}
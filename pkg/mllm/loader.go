@@ -6,6 +6,7 @@ import (
 	"github.com/tmc/langchaingo/documentloaders"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/textsplitter"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -32,6 +33,12 @@ func (c *Client) AddSplitter(opts ...textsplitter.Option) {
 	ops = append(ops, opts...)
 }
 
+// SetTextSplitter 设置自定义文本分割器，例如 SemanticSplitter
+// 设置后 .txt 和 .pdf 文件使用该分割器，.md 文件仍按 markdown 结构进行分割
+func (c *Client) SetTextSplitter(spliter textsplitter.TextSplitter) {
+	c.spliter = spliter
+}
+
 func (c *Client) load(ctx context.Context, filename string) ([]schema.Document, []string, error) {
 	f, err := os.Lstat(filename)
 	if err != nil {
@@ -99,25 +106,20 @@ func (c *Client) loadFile(ctx context.Context, filename string) ([]schema.Docume
 	default:
 		return nil, errors.New("不支持的文档类型:" + ext)
 	}
+	if c.spliter != nil && ext != ".md" {
+		spliter = c.spliter
+	}
 
 	// 加载并拆分文档
-	docs, err := loader.Load(ctx)
+	lctx, s := c.startStage(ctx, StageLoad, slog.String(AttrFilename, filename))
+	docs, err := loader.Load(lctx)
+	s.end(err, slog.Int(AttrDocuments, len(docs)))
 	if err != nil {
 		return nil, err
 	}
 
-	texts := make([]string, len(docs))
-	metadatas := make([]map[string]interface{}, len(docs))
-	for i, doc := range docs {
-		texts[i] = doc.PageContent
-		meta := doc.Metadata
-		for k, v := range metadata {
-			if _, ok := meta[k]; !ok {
-				meta[k] = v
-			}
-		}
-		metadatas[i] = meta
-	}
-
-	return textsplitter.CreateDocuments(spliter, texts, metadatas)
+	_, s = c.startStage(ctx, StageSplit, slog.String(AttrFilename, filename))
+	chunks, err := splitDocuments(spliter, docs, metadata, ext == ".md")
+	s.end(err, slog.Int(AttrChunks, len(chunks)))
+	return chunks, err
 }
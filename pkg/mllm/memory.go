@@ -0,0 +1,793 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"maps"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore 在内存中模拟 MongoDB Atlas 的集合、vectorSearch 索引和 $vectorSearch 检索，用于测试
+//
+// 新建的索引需要被查询 QueryableAfter 次后才可以使用，模拟 Atlas 创建索引的延迟；
+// Find/Count/DeleteMany 支持字段相等和 $in、$nin、$eq、$ne、$gt、$gte、$lt、$lte、$exists 操作符
+type MemoryStore struct {
+	db       *memoryDB
+	collname string
+	idx      string
+	fields   []Field
+	wait     IndexWait // 等待索引的轮询配置，默认每毫秒查询一次
+}
+
+type memoryDB struct {
+	mu             sync.Mutex
+	colls          map[string]*memoryColl
+	queryableAfter int
+	indexFailure   string // 不为空时新建的索引创建失败
+	seq            int
+}
+
+type memoryColl struct {
+	docs    []memoryDoc
+	indexes map[string]*memoryIndex
+}
+
+type memoryDoc struct {
+	id  string
+	doc map[string]any // page_content、metadata 和向量字段
+}
+
+type memoryIndex struct {
+	fields []Field
+	latest []Field // 更新后还未构建完成的字段
+	polls  int     // 创建或更新后被查询的次数
+	built  bool    // 已构建完成，可以查询
+	failed string  // 创建失败的原因
+}
+
+type MemoryOption func(*MemoryStore)
+
+// WithQueryableAfter 设置新建的索引被查询多少次后可以使用，默认 1
+func WithQueryableAfter(n int) MemoryOption {
+	return func(m *MemoryStore) {
+		m.db.queryableAfter = n
+	}
+}
+
+// WithIndexFailure 新建的索引创建失败，状态为 FAILED
+func WithIndexFailure(message string) MemoryOption {
+	return func(m *MemoryStore) {
+		m.db.indexFailure = message
+	}
+}
+
+// WithMemoryIndexWait 设置 CreateIndex 等待索引的轮询配置
+func WithMemoryIndexWait(w IndexWait) MemoryOption {
+	return func(m *MemoryStore) {
+		m.wait = w
+	}
+}
+
+// WithMemoryFields 设置向量索引字段，默认与 MongodbStore 相同
+func WithMemoryFields(fields ...Field) MemoryOption {
+	return func(m *MemoryStore) {
+		m.fields = fields
+	}
+}
+
+// NewMemoryStore 创建内存集合，集合需要通过 CreateCollection 或 Client.SetStore 创建后才存在
+func NewMemoryStore(collname, idx string, opts ...MemoryOption) *MemoryStore {
+	m := &MemoryStore{
+		db:       &memoryDB{colls: make(map[string]*memoryColl), queryableAfter: 1},
+		collname: collname,
+		idx:      idx,
+		fields:   defaultFields(),
+		wait:     IndexWait{Interval: time.Millisecond},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *MemoryStore) Index() string {
+	return m.idx
+}
+
+func (m *MemoryStore) Path() string {
+	return vectorPath(m.fields)
+}
+
+func (m *MemoryStore) Fields() []Field {
+	return m.fields
+}
+
+func (m *MemoryStore) Collection(name string, opts ...CollectionOption) Store {
+	o := newCollectionOptions(m.idx, m.fields, opts)
+	res := *m
+	res.collname, res.idx, res.fields = name, o.idx, o.fields
+	return &res
+}
+
+func (m *MemoryStore) Close(context.Context) error {
+	return nil
+}
+
+// coll 获取集合，调用前需要加锁，集合不存在时返回空集合，与 MongoDB 读取时的行为一致
+func (m *MemoryStore) coll() *memoryColl {
+	if c, ok := m.db.colls[m.collname]; ok {
+		return c
+	}
+	return &memoryColl{}
+}
+
+// createColl 获取集合，调用前需要加锁，集合不存在时自动创建，与 MongoDB 写入时的行为一致
+func (m *MemoryStore) createColl() *memoryColl {
+	c, ok := m.db.colls[m.collname]
+	if !ok {
+		c = &memoryColl{indexes: make(map[string]*memoryIndex)}
+		m.db.colls[m.collname] = c
+	}
+	return c
+}
+
+func (m *MemoryStore) SelectCollection(context.Context) bool {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	_, ok := m.db.colls[m.collname]
+	return ok
+}
+
+func (m *MemoryStore) CreateCollection(context.Context) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	if _, ok := m.db.colls[m.collname]; ok {
+		return fmt.Errorf("集合 %s 已存在", m.collname)
+	}
+	m.createColl()
+	return nil
+}
+
+// SelectIndex 索引存在并且可以查询时返回 true，每次查询都会推进索引的创建进度
+func (m *MemoryStore) SelectIndex(ctx context.Context, idx string) (bool, error) {
+	status, err := m.IndexStatus(ctx, idx)
+	return status.Queryable, err
+}
+
+// IndexStatus 查询索引状态，每次查询都会推进索引的创建进度：PENDING -> BUILDING -> READY
+func (m *MemoryStore) IndexStatus(_ context.Context, idx string) (IndexStatus, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	c, ok := m.db.colls[m.collname]
+	if !ok {
+		return IndexStatus{Name: idx, Status: IndexStatusDoesNotExist}, nil
+	}
+	index, ok := c.indexes[idx]
+	if !ok {
+		return IndexStatus{Name: idx, Status: IndexStatusDoesNotExist}, nil
+	}
+	index.advance(m.db.queryableAfter)
+	return index.info(idx, m.db.queryableAfter).IndexStatus, nil
+}
+
+// advance 推进索引的构建进度，构建完成后使用最新的字段
+func (index *memoryIndex) advance(queryableAfter int) {
+	if index.failed != "" {
+		return
+	}
+	index.polls++
+	if index.polls >= queryableAfter {
+		index.built = true
+		if index.latest != nil {
+			index.fields, index.latest = index.latest, nil
+		}
+	}
+}
+
+// info 索引状态及最新定义，更新索引时旧的定义在新定义构建完成前仍然可以查询
+func (index *memoryIndex) info(name string, queryableAfter int) IndexInfo {
+	info := IndexInfo{IndexStatus: IndexStatus{Name: name}, Type: VectorSearchType, Fields: index.fields}
+	if index.latest != nil {
+		info.Fields = index.latest
+	}
+	switch {
+	case index.failed != "":
+		info.Status, info.Message = IndexStatusFailed, index.failed
+	case index.latest == nil && index.polls >= queryableAfter:
+		info.Status = IndexStatusReady
+	case index.polls <= 1:
+		info.Status = IndexStatusPending
+	default:
+		info.Status = IndexStatusBuilding
+	}
+	info.Queryable = index.built
+	return info
+}
+
+// ListIndexes 获取集合中所有索引的状态及定义，按名称排序，不推进索引的创建进度
+func (m *MemoryStore) ListIndexes(_ context.Context) ([]IndexInfo, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	c, ok := m.db.colls[m.collname]
+	if !ok {
+		return nil, nil
+	}
+	list := make([]IndexInfo, 0, len(c.indexes))
+	for name, index := range c.indexes {
+		list = append(list, index.info(name, m.db.queryableAfter))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// CreateIndex 创建索引并等待索引可以查询
+func (m *MemoryStore) CreateIndex(ctx context.Context, idx string, fields []Field) error {
+	if len(fields) < 1 {
+		return errors.New("索引字段不能为空")
+	}
+
+	m.db.mu.Lock()
+	c := m.createColl()
+	if _, ok := c.indexes[idx]; ok {
+		m.db.mu.Unlock()
+		return fmt.Errorf("索引 %s 已存在", idx)
+	}
+	c.indexes[idx] = &memoryIndex{fields: fields, failed: m.db.indexFailure}
+	m.db.mu.Unlock()
+
+	return waitIndex(ctx, m.wait, idx, func(ctx context.Context) (IndexStatus, error) {
+		return m.IndexStatus(ctx, idx)
+	}, indexQueryable)
+}
+
+// UpdateIndex 更新索引字段并等待新的定义构建完成，构建期间旧的定义仍然可以查询
+func (m *MemoryStore) UpdateIndex(ctx context.Context, idx string, fields []Field) error {
+	if len(fields) < 1 {
+		return errors.New("索引字段不能为空")
+	}
+
+	m.db.mu.Lock()
+	c, ok := m.db.colls[m.collname]
+	var index *memoryIndex
+	if ok {
+		index, ok = c.indexes[idx]
+	}
+	if !ok {
+		m.db.mu.Unlock()
+		return fmt.Errorf("索引 %s 不存在", idx)
+	}
+	index.latest, index.polls, index.failed = fields, 0, m.db.indexFailure
+	m.db.mu.Unlock()
+
+	return waitIndex(ctx, m.wait, idx, func(ctx context.Context) (IndexStatus, error) {
+		return m.IndexStatus(ctx, idx)
+	}, func(s IndexStatus) bool {
+		return s.Status == IndexStatusReady
+	})
+}
+
+func (m *MemoryStore) DropIndex(_ context.Context, idx string) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	c, ok := m.db.colls[m.collname]
+	if !ok {
+		return fmt.Errorf("集合 %s 不存在", m.collname)
+	}
+	if _, ok = c.indexes[idx]; !ok {
+		return fmt.Errorf("索引 %s 不存在", idx)
+	}
+	delete(c.indexes, idx)
+	return nil
+}
+
+func (m *MemoryStore) Find(_ context.Context, filter bson.M, sortKey string) ([]Vector, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	res := make([]Vector, 0)
+	keys := make([]any, 0)
+	for _, d := range m.coll().docs {
+		ok, err := matchFilter(d.doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			page, _ := d.doc["page_content"].(string)
+			meta, _ := d.doc["metadata"].(map[string]any)
+			res = append(res, Vector{ID: d.id, PageContent: page, Metadata: maps.Clone(meta)})
+			v, _ := lookupPath(d.doc, sortKey)
+			keys = append(keys, v)
+		}
+	}
+
+	if sortKey != "" {
+		idx := make([]int, len(res))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(i, j int) bool { return compareValues(keys[idx[i]], keys[idx[j]]) < 0 })
+		sorted := make([]Vector, 0, len(res))
+		for _, i := range idx {
+			sorted = append(sorted, res[i])
+		}
+		res = sorted
+	}
+	return res, nil
+}
+
+// Scan 按 _id 升序获取 _id 大于 after 的分块，最多 limit 个，Embedding 为保存的向量
+func (m *MemoryStore) Scan(_ context.Context, filter bson.M, after string, limit int) ([]Vector, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	res := make([]Vector, 0, limit)
+	for _, d := range m.coll().docs {
+		if d.id <= after {
+			continue
+		}
+		ok, err := matchFilter(d.doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		page, _ := d.doc["page_content"].(string)
+		meta, _ := d.doc["metadata"].(map[string]any)
+		vec, _ := d.doc[m.Path()].([]float32)
+		res = append(res, Vector{ID: d.id, PageContent: page, Metadata: maps.Clone(meta), Embedding: vec})
+		if len(res) >= limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// UpdateEmbeddings 按 ID 更新分块的向量和元数据
+func (m *MemoryStore) UpdateEmbeddings(_ context.Context, vectors []Vector) error {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	c := m.coll()
+	pos := make(map[string]int, len(c.docs))
+	for i, d := range c.docs {
+		pos[d.id] = i
+	}
+	for _, v := range vectors {
+		i, ok := pos[v.ID]
+		if !ok {
+			return fmt.Errorf("分块 %s 不存在", v.ID)
+		}
+		c.docs[i].doc[m.Path()] = v.Embedding
+		c.docs[i].doc["metadata"] = maps.Clone(v.Metadata)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	list, err := m.Find(ctx, filter, "")
+	return int64(len(list)), err
+}
+
+func (m *MemoryStore) DeleteMany(_ context.Context, filter bson.M) (int64, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	c := m.coll()
+	docs := c.docs[:0]
+	var n int64
+	for _, d := range c.docs {
+		ok, err := matchFilter(d.doc, filter)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			n++
+			continue
+		}
+		docs = append(docs, d)
+	}
+	c.docs = docs
+	return n, nil
+}
+
+func (m *MemoryStore) Sources(ctx context.Context, filter bson.M) ([]Source, error) {
+	list, err := m.Find(ctx, filter, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]*Source)
+	for _, v := range list {
+		filename, _ := v.Metadata[FilenameKey].(string)
+		updated, _ := v.Metadata[UpdatedTime].(string)
+		s, ok := sources[filename]
+		if !ok {
+			s = &Source{Filename: filename}
+			sources[filename] = s
+		}
+		s.Chunks++
+		if updated > s.UpdatedTime {
+			s.UpdatedTime = updated
+		}
+	}
+
+	res := make([]Source, 0, len(sources))
+	for _, s := range sources {
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Filename < res[j].Filename })
+	return res, nil
+}
+
+// VectorStore 基于内存集合的向量库，检索时校验索引的状态、向量维度和过滤字段
+func (m *MemoryStore) VectorStore(emb embeddings.Embedder) vectorstores.VectorStore {
+	return &memoryVectorStore{store: m, emb: emb}
+}
+
+type memoryVectorStore struct {
+	store *MemoryStore
+	emb   embeddings.Embedder
+}
+
+func (s *memoryVectorStore) AddDocuments(ctx context.Context, docs []schema.Document, opts ...vectorstores.Option) ([]string, error) {
+	emb := s.emb
+	if o := vectorstoreOptions(opts); o.Embedder != nil {
+		emb = o.Embedder
+	}
+	texts := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		texts = append(texts, doc.PageContent)
+	}
+	vecs, err := emb.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vecs) != len(docs) {
+		return nil, errors.New("向量数量与文档数量不一致")
+	}
+
+	m := s.store
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+	c := m.createColl()
+	ids := make([]string, 0, len(docs))
+	for i, doc := range docs {
+		m.db.seq++
+		id := fmt.Sprintf("%024x", m.db.seq) // 与 ObjectID 的十六进制格式一致，按插入顺序排序
+		meta := make(map[string]any, len(doc.Metadata))
+		for k, v := range doc.Metadata {
+			meta[k] = v
+		}
+		c.docs = append(c.docs, memoryDoc{id: id, doc: map[string]any{
+			"_id":          id,
+			"page_content": doc.PageContent,
+			"metadata":     meta,
+			m.Path():       vecs[i],
+		}})
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SimilaritySearch 模拟 $vectorSearch，分数计算方式与 Atlas 相同：
+// cosine 和 dotProduct 为 (1 + 相似度) / 2，euclidean 为 1 / (1 + 距离)
+func (s *memoryVectorStore) SimilaritySearch(ctx context.Context, query string, k int, opts ...vectorstores.Option) ([]schema.Document, error) {
+	o := vectorstoreOptions(opts)
+	emb := s.emb
+	if o.Embedder != nil {
+		emb = o.Embedder
+	}
+	vec, err := emb.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	m := s.store
+	field, filters, err := m.searchIndex(len(vec))
+	if err != nil {
+		return nil, err
+	}
+
+	var filter bson.M
+	if o.Filters != nil {
+		if filter, err = toFilter(o.Filters); err != nil {
+			return nil, err
+		}
+		for key := range filter {
+			if !strings.HasPrefix(key, "$") && !filters[key] {
+				return nil, fmt.Errorf("字段 %s 没有在索引 %s 中声明为 filter", key, m.idx)
+			}
+		}
+	}
+
+	m.db.mu.Lock()
+	res := make([]schema.Document, 0)
+	for _, d := range m.coll().docs {
+		if ok, err := matchFilter(d.doc, filter); err != nil || !ok {
+			if err != nil {
+				m.db.mu.Unlock()
+				return nil, err
+			}
+			continue
+		}
+		dv, _ := d.doc[field.Path].([]float32)
+		if len(dv) != len(vec) {
+			continue
+		}
+
+		score := vectorScore(field.Similarity, vec, dv)
+		if score < o.ScoreThreshold {
+			continue
+		}
+		page, _ := d.doc["page_content"].(string)
+		meta, _ := d.doc["metadata"].(map[string]any)
+		res = append(res, schema.Document{PageContent: page, Metadata: meta, Score: score})
+	}
+	m.db.mu.Unlock()
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+	if len(res) > k {
+		res = res[:k]
+	}
+	return res, nil
+}
+
+// searchIndex 获取可以查询的向量索引，返回向量字段和 filter 字段
+func (m *MemoryStore) searchIndex(dims int) (Field, map[string]bool, error) {
+	m.db.mu.Lock()
+	defer m.db.mu.Unlock()
+
+	index, ok := m.coll().indexes[m.idx]
+	if !ok || !index.built {
+		return Field{}, nil, fmt.Errorf("索引 %s 不存在或不可查询", m.idx)
+	}
+
+	var (
+		field   Field
+		filters = make(map[string]bool)
+	)
+	for _, f := range index.fields {
+		switch f.Type {
+		case FieldTypeVector:
+			field = f
+		case FieldTypeFilter:
+			filters[f.Path] = true
+		}
+	}
+	if field.Path == "" {
+		return Field{}, nil, fmt.Errorf("索引 %s 没有向量字段", m.idx)
+	}
+	if field.NumDimensions != dims {
+		return Field{}, nil, fmt.Errorf("向量维度 %d 与索引 %s 的维度 %d 不一致", dims, m.idx, field.NumDimensions)
+	}
+	return field, filters, nil
+}
+
+func vectorScore(similarity FieldSimilarity, a, b []float32) float32 {
+	switch similarity {
+	case FieldSimilarityEuclidean:
+		var sum float64
+		for i := range a {
+			d := float64(a[i] - b[i])
+			sum += d * d
+		}
+		return float32(1 / (1 + math.Sqrt(sum)))
+	case FieldSimilarityDotProduct:
+		var dot float64
+		for i := range a {
+			dot += float64(a[i]) * float64(b[i])
+		}
+		return float32((1 + dot) / 2)
+	default:
+		return float32((1 + cosine(a, b)) / 2)
+	}
+}
+
+func vectorstoreOptions(opts []vectorstores.Option) vectorstores.Options {
+	var o vectorstores.Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// toFilter 将 bson.D、bson.M、map 类型的过滤条件转换为 bson.M
+func toFilter(v any) (bson.M, error) {
+	switch f := v.(type) {
+	case bson.M:
+		return f, nil
+	case map[string]any:
+		return f, nil
+	case bson.D:
+		res := make(bson.M, len(f))
+		for _, e := range f {
+			res[e.Key] = e.Value
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("不支持的过滤条件类型 %T", v)
+	}
+}
+
+// matchLogical 判断文档是否满足 $and 或 $or 中的过滤条件
+func matchLogical(doc map[string]any, op string, cond any) (bool, error) {
+	rv := reflect.ValueOf(cond)
+	if rv.Kind() != reflect.Slice || rv.Len() < 1 {
+		return false, fmt.Errorf("%s 的参数必须是非空数组", op)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		sub, err := toFilter(rv.Index(i).Interface())
+		if err != nil {
+			return false, err
+		}
+		ok, err := matchFilter(doc, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok == (op == "$or") {
+			return ok, nil
+		}
+	}
+	return op == "$and", nil
+}
+
+// matchFilter 判断文档是否满足过滤条件
+func matchFilter(doc map[string]any, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		if key == "$and" || key == "$or" {
+			ok, err := matchLogical(doc, key, cond)
+			if err != nil || !ok {
+				return false, err
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			return false, fmt.Errorf("不支持的操作符 %s", key)
+		}
+		val, exists := lookupPath(doc, key)
+
+		ops, err := toFilter(cond)
+		if err != nil || !isOperators(ops) {
+			// 字段相等
+			ops = bson.M{"$eq": cond}
+		}
+
+		for op, arg := range ops {
+			ok, err := matchValue(op, val, exists, arg)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+func isOperators(ops bson.M) bool {
+	for k := range ops {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return len(ops) > 0
+}
+
+// matchValue 字段为数组时任意元素满足条件即可，$ne 和 $nin 要求所有元素都满足，与 mongodb 一致
+func matchValue(op string, val any, exists bool, arg any) (bool, error) {
+	rv := reflect.ValueOf(val)
+	if !exists || op == "$exists" || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Type().Elem().Kind() == reflect.Uint8 {
+		return matchOperator(op, val, exists, arg)
+	}
+
+	negate := op == "$ne" || op == "$nin"
+	for i := 0; i < rv.Len(); i++ {
+		ok, err := matchOperator(op, rv.Index(i).Interface(), true, arg)
+		if err != nil {
+			return false, err
+		}
+		if ok != negate {
+			return !negate, nil
+		}
+	}
+	return negate, nil
+}
+
+func matchOperator(op string, val any, exists bool, arg any) (bool, error) {
+	switch op {
+	case "$eq":
+		return exists && compareValues(val, arg) == 0, nil
+	case "$ne":
+		return !exists || compareValues(val, arg) != 0, nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if !exists {
+			return false, nil
+		}
+		c := compareValues(val, arg)
+		switch op {
+		case "$gt":
+			return c > 0, nil
+		case "$gte":
+			return c >= 0, nil
+		case "$lt":
+			return c < 0, nil
+		default:
+			return c <= 0, nil
+		}
+	case "$in", "$nin":
+		rv := reflect.ValueOf(arg)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return false, fmt.Errorf("%s 的参数必须是数组", op)
+		}
+		in := false
+		for i := 0; i < rv.Len(); i++ {
+			if exists && compareValues(val, rv.Index(i).Interface()) == 0 {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$exists":
+		want, _ := arg.(bool)
+		return exists == want, nil
+	default:
+		return false, fmt.Errorf("不支持的操作符 %s", op)
+	}
+}
+
+// lookupPath 按 a.b.c 路径获取字段
+func lookupPath(doc map[string]any, path string) (any, bool) {
+	if path == "" {
+		return nil, false
+	}
+	var cur any = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// compareValues 比较两个值，数字按数值比较，其他类型按字符串比较
+func compareValues(a, b any) int {
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	if i, ok := toInt(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
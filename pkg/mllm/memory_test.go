@@ -0,0 +1,346 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"os"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+	"time"
+)
+
+func TestSetStoreCreatesCollectionAndIndex(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	store := NewMemoryStore("vector", "vector_index", WithMemoryFields(field), WithQueryableAfter(3))
+
+	if store.SelectCollection(ctx) {
+		t.Fatal("集合不应存在")
+	}
+	if err := c.SetStore(ctx, store); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+	if !store.SelectCollection(ctx) {
+		t.Fatal("SetStore 应创建集合")
+	}
+	if ok, _ := store.SelectIndex(ctx, "vector_index"); !ok {
+		t.Fatal("SetStore 应等待索引可以查询")
+	}
+
+	// 集合和索引已存在时不重复创建
+	if err := c.SetStore(ctx, store); err != nil {
+		t.Fatalf("SetStore again: %v", err)
+	}
+	if err := store.CreateCollection(ctx); err == nil {
+		t.Fatal("重复创建集合应返回错误")
+	}
+	if err := store.CreateIndex(ctx, "vector_index", store.Fields()); err == nil {
+		t.Fatal("重复创建索引应返回错误")
+	}
+}
+
+func TestMemoryIndexQueryable(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("vector", "idx", WithQueryableAfter(2))
+	if err := store.CreateCollection(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.SelectIndex(ctx, "idx"); ok {
+		t.Fatal("索引不存在时不可查询")
+	}
+
+	store.db.colls["vector"].indexes["idx"] = &memoryIndex{fields: store.Fields()}
+	if ok, _ := store.SelectIndex(ctx, "idx"); ok {
+		t.Fatal("第1次查询时索引不应可用")
+	}
+	if ok, _ := store.SelectIndex(ctx, "idx"); !ok {
+		t.Fatal("第2次查询时索引应可用")
+	}
+
+	if err := store.DropIndex(ctx, "idx"); err != nil {
+		t.Fatalf("DropIndex: %v", err)
+	}
+	if err := store.DropIndex(ctx, "idx"); err == nil {
+		t.Fatal("删除不存在的索引应返回错误")
+	}
+}
+
+func TestAddDocumentsDedup(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	store := useMemoryStore(t, c, srv)
+
+	filename := writeFile(t, "doc.txt", "第一段内容。\n\n第二段内容。")
+	ids, err := c.AddDocuments(ctx, filename)
+	if err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	total := countDocs(t, store)
+	if total != len(ids) {
+		t.Fatalf("docs = %d, ids = %d", total, len(ids))
+	}
+
+	// 文件没有修改时不重复添加
+	ids, err = c.AddDocuments(ctx, filename)
+	if err != nil {
+		t.Fatalf("AddDocuments again: %v", err)
+	}
+	if len(ids) != 0 || countDocs(t, store) != total {
+		t.Fatalf("未修改的文件被重复添加：ids = %v", ids)
+	}
+
+	// 修改时间变化后重新添加
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments after change: %v", err)
+	}
+	if len(ids) != total {
+		t.Fatalf("修改后的文件应重新添加：ids = %v", ids)
+	}
+	// 写入新版本后删除旧版本的分块
+	if n := countDocs(t, store); n != total {
+		t.Fatalf("docs = %d, want %d", n, total)
+	}
+}
+
+func TestSourcesAndDelete(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+
+	a := writeFile(t, "a.txt", "文件 a 的内容。")
+	b := writeFile(t, "b.txt", "文件 b 的内容。")
+	for _, f := range []string{a, b} {
+		if _, err := c.AddDocuments(ctx, f); err != nil {
+			t.Fatalf("AddDocuments: %v", err)
+		}
+	}
+
+	sources, err := c.ListSources(ctx)
+	if err != nil {
+		t.Fatalf("ListSources: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Filename != a || sources[1].Filename != b || sources[0].Chunks < 1 {
+		t.Fatalf("sources = %+v", sources)
+	}
+
+	n, err := c.DeleteBySource(ctx, a)
+	if err != nil || n != int64(sources[0].Chunks) {
+		t.Fatalf("DeleteBySource = %d, %v", n, err)
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil || stats.Sources != 1 || stats.Chunks != sources[1].Chunks {
+		t.Fatalf("Stats = %+v, %v", stats, err)
+	}
+
+	// $or 中的元数据字段同样添加 metadata. 前缀
+	n, err = c.DeleteByFilter(ctx, map[string]any{"$or": []map[string]any{{FilenameKey: a}, {FilenameKey: b}}})
+	if err != nil || n != int64(sources[1].Chunks) {
+		t.Fatalf("DeleteByFilter = %d, %v", n, err)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	c.SetTextSplitter(NewChineseSplitter(textsplitter.WithChunkSize(8), textsplitter.WithChunkOverlap(0)))
+
+	filename := writeFile(t, "doc.txt", "第一句话。第二句话。第三句话。第四句话。第五句话。")
+	if _, err := c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+
+	doc := schema.Document{Metadata: map[string]any{FilenameKey: filename, ChunkIndexKey: 2}}
+	docs, err := c.Neighbors(ctx, doc, 1)
+	if err != nil {
+		t.Fatalf("Neighbors: %v", err)
+	}
+	if len(docs) != 3 {
+		t.Fatalf("Neighbors = %d 个分块，want 3", len(docs))
+	}
+	for i, d := range docs {
+		if idx, _ := toInt(d.Metadata[ChunkIndexKey]); idx != i+1 {
+			t.Fatalf("docs[%d] chunk_index = %v", i, d.Metadata[ChunkIndexKey])
+		}
+	}
+}
+
+func TestMemoryVectorSearch(t *testing.T) {
+	ctx := context.Background()
+	srv := ollamatest.NewServer()
+	t.Cleanup(srv.Close)
+	c, err := NewLLM("test-model", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := []Field{
+		{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine},
+		{Type: FieldTypeFilter, Path: "metadata.lang"},
+	}
+	store := NewMemoryStore("vector", "idx", WithMemoryFields(fields...))
+	vs := store.VectorStore(emb)
+
+	docs := []schema.Document{
+		{PageContent: "mongodb atlas vector search", Metadata: map[string]any{"lang": "en"}},
+		{PageContent: "ollama runs models locally", Metadata: map[string]any{"lang": "en"}},
+		{PageContent: "向量 检索", Metadata: map[string]any{"lang": "zh"}},
+	}
+	if _, err = vs.AddDocuments(ctx, docs); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+
+	// 索引不存在时不可检索
+	if _, err = vs.SimilaritySearch(ctx, "vector search", 1); err == nil {
+		t.Fatal("索引不存在时应返回错误")
+	}
+	if err = store.CreateIndex(ctx, "idx", fields); err != nil {
+		t.Fatalf("CreateIndex: %v", err)
+	}
+
+	res, err := vs.SimilaritySearch(ctx, "atlas vector search", 2)
+	if err != nil {
+		t.Fatalf("SimilaritySearch: %v", err)
+	}
+	if len(res) != 2 || !strings.Contains(res[0].PageContent, "atlas") || res[0].Score < res[1].Score {
+		t.Fatalf("SimilaritySearch = %+v", res)
+	}
+
+	res, err = vs.SimilaritySearch(ctx, "atlas vector search", 3, vectorstores.WithFilters(bson.D{{Key: "metadata.lang", Value: "zh"}}))
+	if err != nil || len(res) != 1 || res[0].Metadata["lang"] != "zh" {
+		t.Fatalf("SimilaritySearch with filter = %+v, %v", res, err)
+	}
+
+	// 过滤字段必须在索引中声明
+	_, err = vs.SimilaritySearch(ctx, "atlas", 3, vectorstores.WithFilters(bson.M{"metadata.other": "x"}))
+	if err == nil {
+		t.Fatal("未声明的过滤字段应返回错误")
+	}
+
+	// 维度与索引不一致
+	if err = store.DropIndex(ctx, "idx"); err != nil {
+		t.Fatal(err)
+	}
+	fields[0].NumDimensions = 8
+	if err = store.CreateIndex(ctx, "idx", fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vs.SimilaritySearch(ctx, "atlas", 1); err == nil || !strings.Contains(err.Error(), "维度") {
+		t.Fatalf("维度不一致时应返回错误：%v", err)
+	}
+}
+
+func TestMatchFilter(t *testing.T) {
+	doc := map[string]any{"metadata": map[string]any{"filename": "a.txt", "chunk_index": 3, "page": 1.0, "files": []string{"a.txt", "b.txt"}}}
+	cases := []struct {
+		filter bson.M
+		want   bool
+	}{
+		{bson.M{"metadata.filename": "a.txt"}, true},
+		{bson.M{"metadata.filename": "b.txt"}, false},
+		{bson.M{"metadata.filename": bson.M{"$in": []string{"a.txt", "b.txt"}}}, true},
+		{bson.M{"metadata.filename": bson.M{"$nin": []string{"a.txt"}}}, false},
+		{bson.M{"metadata.chunk_index": bson.M{"$gte": 2, "$lte": 3}}, true},
+		{bson.M{"metadata.chunk_index": bson.M{"$gt": 3}}, false},
+		{bson.M{"metadata.page": 1}, true},
+		{bson.M{"metadata.missing": bson.M{"$exists": false}}, true},
+		{bson.M{"metadata.missing": bson.M{"$ne": "x"}}, true},
+		{bson.M{"metadata.filename": "a.txt", "metadata.chunk_index": 4}, false},
+		{bson.M{"$or": bson.A{bson.M{"metadata.filename": "b.txt"}, bson.M{"metadata.page": 1}}}, true},
+		{bson.M{"metadata.files": "b.txt"}, true},
+		{bson.M{"metadata.files": bson.M{"$in": []string{"c.txt", "a.txt"}}}, true},
+		{bson.M{"metadata.files": bson.M{"$nin": []string{"b.txt"}}}, false},
+		{bson.M{"metadata.files": bson.M{"$ne": "c.txt"}}, true},
+		{bson.M{"$or": bson.A{bson.M{"metadata.filename": "b.txt"}, bson.M{"metadata.page": 2}}}, false},
+		{bson.M{"$and": []bson.M{{"metadata.filename": "a.txt"}, {"metadata.chunk_index": 3}}}, true},
+		{bson.M{"$and": []bson.M{{"metadata.filename": "a.txt"}, {"metadata.chunk_index": 4}}}, false},
+	}
+	for _, tc := range cases {
+		got, err := matchFilter(doc, tc.filter)
+		if err != nil || got != tc.want {
+			t.Errorf("matchFilter(%v) = %v, %v, want %v", tc.filter, got, err, tc.want)
+		}
+	}
+
+	if _, err := matchFilter(doc, bson.M{"metadata.filename": bson.M{"$regex": "a"}}); err == nil {
+		t.Error("不支持的操作符应返回错误")
+	}
+}
+
+// closeRecorder 记录 Close 的调用次数
+type closeRecorder struct {
+	*MemoryStore
+	closed int
+}
+
+func (s *closeRecorder) Close(ctx context.Context) error {
+	s.closed++
+	return s.MemoryStore.Close(ctx)
+}
+
+func TestSetStoreClosesReplaced(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	a := &closeRecorder{MemoryStore: NewMemoryStore("a", "vector_index", WithMemoryFields(field))}
+	b := &closeRecorder{MemoryStore: NewMemoryStore("b", "vector_index", WithMemoryFields(field))}
+
+	if err := c.SetStore(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddKnowledgeBase(ctx, "kb", ""); err != nil {
+		t.Fatal(err)
+	}
+	// 重复设置同一个集合时不关闭
+	if err := c.SetStore(ctx, a); err != nil || a.closed != 0 {
+		t.Fatalf("SetStore: %v, closed = %d", err, a.closed)
+	}
+	if err := c.SetStore(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if a.closed != 1 || b.closed != 0 {
+		t.Fatalf("closed a = %d, b = %d", a.closed, b.closed)
+	}
+	if names := c.KnowledgeBases(); len(names) != 0 {
+		t.Fatalf("旧连接上的知识库应被移除：%v", names)
+	}
+}
+
+func TestMemoryStoreReadsDoNotCreateCollection(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStore("vector", "vector_index")
+
+	if _, err := store.Find(ctx, bson.M{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Scan(ctx, bson.M{}, "", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DeleteMany(ctx, bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.VectorStore(emb).SimilaritySearch(ctx, "a", 1); err == nil {
+		t.Fatal("没有索引时应返回错误")
+	}
+	if store.SelectCollection(ctx) {
+		t.Fatal("读取和删除不应创建集合")
+	}
+}
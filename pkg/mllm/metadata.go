@@ -0,0 +1,192 @@
+package mllm
+
+import (
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
+	"strings"
+	"unicode/utf8"
+)
+
+// 分块后写入到元数据中的字段，用于引用来源和获取相邻分块
+var (
+	ChunkIndexKey = "chunk_index" // 分块在文件中的序号，从0开始
+	ChunkTotalKey = "chunk_total" // 文件拆分后的分块总数
+	StartIndexKey = "start_index" // 分块在所在页中的起始字符位置，找不到时为-1
+	EndIndexKey   = "end_index"   // 分块在所在页中的结束字符位置（不包含）
+	StartByteKey  = "start_byte"  // 分块在所在页中的起始字节位置
+	EndByteKey    = "end_byte"    // 分块在所在页中的结束字节位置（不包含）
+	PageKey       = "page"        // pdf 页码，与 documentloaders.PDF 保持一致
+	HeadersKey    = "headers"     // markdown 标题层级，例：["文档解析", "加载器"]
+	SectionKey    = "section"     // 标题层级拼接后的字符串
+)
+
+// sectionSep 标题层级拼接时使用的分隔符
+const sectionSep = " > "
+
+// splitDocuments 对加载后的文档进行拆分，并在每个分块中添加位置信息，markdown 为 true 时添加标题层级
+func splitDocuments(spliter textsplitter.TextSplitter, docs []schema.Document, metadata map[string]string, markdown bool) ([]schema.Document, error) {
+	var (
+		chunks  = make([]schema.Document, 0, len(docs))
+		headers = make([]string, 0, 6)
+		inFence bool
+	)
+
+	for _, doc := range docs {
+		texts, err := spliter.SplitText(doc.PageContent)
+		if err != nil {
+			return nil, err
+		}
+
+		cursor, window := 0, 0
+		for _, text := range texts {
+			meta := make(map[string]any, len(doc.Metadata)+len(metadata)+8)
+			for k, v := range doc.Metadata {
+				meta[k] = v
+			}
+			for k, v := range metadata {
+				if _, ok := meta[k]; !ok {
+					meta[k] = v
+				}
+			}
+
+			// 计算分块在原文中的位置，分块之间可能存在重叠，所以从上一个分块的起始位置之后开始查找，
+			// 找不到时最多向前查找上一个分块的长度
+			start, end := locate(doc.PageContent, text, cursor, window)
+			if start >= 0 {
+				meta[StartByteKey] = start
+				meta[EndByteKey] = end
+				meta[StartIndexKey] = utf8.RuneCountInString(doc.PageContent[:start])
+				meta[EndIndexKey] = utf8.RuneCountInString(doc.PageContent[:end])
+				cursor, window = start+1, end-start
+			} else {
+				meta[StartByteKey], meta[EndByteKey] = -1, -1
+				meta[StartIndexKey], meta[EndIndexKey] = -1, -1
+			}
+
+			// 分块以标题开头时，分块属于该标题，否则属于上一个分块结束时的标题
+			if markdown {
+				headers, inFence = walkHeaders(headers, text, inFence, true)
+				if len(headers) > 0 {
+					meta[HeadersKey] = append([]string(nil), headers...)
+					meta[SectionKey] = strings.Join(headers, sectionSep)
+				}
+				headers, inFence = walkHeaders(headers, text, inFence, false)
+			}
+
+			meta[ChunkIndexKey] = len(chunks)
+			chunks = append(chunks, schema.Document{PageContent: text, Metadata: meta})
+		}
+	}
+
+	for i := range chunks {
+		chunks[i].Metadata[ChunkTotalKey] = len(chunks)
+	}
+	return chunks, nil
+}
+
+// locate 查找分块在原文中的字节位置 [start, end)，找不到时返回-1
+// 拆分器会去除首尾空白，markdown 拆分器还会在分块前补充标题并调整空行，
+// 所以整体匹配失败时按分块的首行和末行确定范围
+func locate(source, chunk string, from, window int) (int, int) {
+	chunk = strings.TrimSpace(chunk)
+	if chunk == "" || from > len(source) {
+		return -1, -1
+	}
+	if i := indexFrom(source, chunk, from, window); i >= 0 {
+		return i, i + len(chunk)
+	}
+
+	lines := strings.Split(chunk, "\n")
+	for len(lines) > 1 {
+		if level, _ := parseHeader(strings.TrimSpace(lines[0])); level == 0 && strings.TrimSpace(lines[0]) != "" {
+			break
+		}
+		lines = lines[1:]
+	}
+	first, last := strings.TrimSpace(lines[0]), strings.TrimSpace(lines[len(lines)-1])
+
+	start := indexFrom(source, first, from, window)
+	if start < 0 {
+		return -1, -1
+	}
+	end := start + len(first)
+	if i := strings.Index(source[end:], last); len(lines) > 1 && i >= 0 {
+		end += i + len(last)
+	}
+	return start, end
+}
+
+// indexFrom 从 from 开始查找子串，找不到时在 from 之前 window 个字节内查找，分块之间存在重叠时可能出现在 from 之前
+func indexFrom(source, substr string, from, window int) int {
+	if i := strings.Index(source[from:], substr); i >= 0 {
+		return from + i
+	}
+	lo, hi := max(from-window, 0), min(from+len(substr)-1, len(source))
+	if i := strings.Index(source[lo:hi], substr); i >= 0 {
+		return lo + i
+	}
+	return -1
+}
+
+// walkHeaders 根据分块中的 markdown 标题更新标题层级，代码块中的 # 不作为标题处理
+// leading 为 true 时只处理分块开头连续的标题，不改变代码块状态
+func walkHeaders(headers []string, text string, inFence, leading bool) ([]string, bool) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if leading && line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			if leading {
+				break
+			}
+			inFence = !inFence
+			continue
+		}
+
+		level, title := 0, ""
+		if !inFence {
+			level, title = parseHeader(line)
+		}
+		if level == 0 {
+			if leading {
+				break
+			}
+			continue
+		}
+
+		if level > len(headers)+1 {
+			level = len(headers) + 1
+		}
+		headers = append(headers[:level-1], title)
+	}
+	return headers, inFence
+}
+
+// parseHeader 解析 ATX 风格的 markdown 标题，返回标题级别和标题内容
+func parseHeader(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(strings.TrimRight(line[level:], "#"))
+}
+
+// toInt 将元数据中的数值转换为 int，从 mongodb 中读取的数值可能是 int32 或 int64
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
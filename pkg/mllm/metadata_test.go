@@ -0,0 +1,51 @@
+package mllm
+
+import (
+	"github.com/tmc/langchaingo/schema"
+	"github.com/tmc/langchaingo/textsplitter"
+	"testing"
+)
+
+func TestIndexFrom(t *testing.T) {
+	source := "abc 0123456789 def"
+	cases := []struct {
+		substr       string
+		from, window int
+		want         int
+	}{
+		{"def", 4, 0, 15},
+		{"abc", 4, 4, 0},   // 在重叠范围内向前查找
+		{"abc", 10, 4, -1}, // 超出重叠范围时不从头查找
+		{"3456", 7, 2, 7},
+		{"2345", 7, 2, 6},
+	}
+	for _, tc := range cases {
+		if got := indexFrom(source, tc.substr, tc.from, tc.window); got != tc.want {
+			t.Errorf("indexFrom(%q, %d, %d) = %d, want %d", tc.substr, tc.from, tc.window, got, tc.want)
+		}
+	}
+}
+
+func TestSplitDocumentsHeaders(t *testing.T) {
+	text := "# 标题\n\n正文内容。"
+	spliter := textsplitter.NewRecursiveCharacter(textsplitter.WithChunkSize(100), textsplitter.WithChunkOverlap(0))
+	docs := []schema.Document{{PageContent: text, Metadata: map[string]any{}}}
+
+	// 只有 markdown 文件解析标题，txt、pdf 中 # 开头的行是正文
+	for _, markdown := range []bool{true, false} {
+		chunks, err := splitDocuments(spliter, docs, nil, markdown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) != 1 {
+			t.Fatalf("chunks = %+v", chunks)
+		}
+		section, ok := chunks[0].Metadata[SectionKey]
+		if markdown && section != "标题" || !markdown && ok {
+			t.Fatalf("markdown = %v, section = %v", markdown, section)
+		}
+		if chunks[0].Metadata[StartByteKey] != 0 {
+			t.Fatalf("start_byte = %v", chunks[0].Metadata[StartByteKey])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package mllm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// 指标名称
+const (
+	MetricStageDuration      = "mllm_stage_duration_seconds"    // 各阶段耗时，标签 stage、status(ok|error)
+	MetricTokens             = "mllm_tokens_total"              // 模型使用的 token 数量，标签 type(prompt|completion)
+	MetricEmbeddedTexts      = "mllm_embedded_texts_total"      // 调用模型生成向量的文本数量
+	MetricStoredChunks       = "mllm_stored_chunks_total"       // 保存的分块数量
+	MetricRetrievals         = "mllm_retrievals_total"          // 检索次数，标签 result(hit|miss)，miss 为没有检索到分块
+	MetricRetrievedDocuments = "mllm_retrieved_documents_total" // 检索到的分块数量
+)
+
+var metricHelp = map[string]string{
+	MetricStageDuration:      "Duration of each stage in seconds.",
+	MetricTokens:             "Number of tokens used by the model.",
+	MetricEmbeddedTexts:      "Number of texts embedded by the model.",
+	MetricStoredChunks:       "Number of chunks stored.",
+	MetricRetrievals:         "Number of retrievals by result.",
+	MetricRetrievedDocuments: "Number of documents retrieved.",
+}
+
+// DurationBuckets 阶段耗时直方图的桶，单位秒，覆盖单次 embed 到等待索引构建
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 600}
+
+type histogram struct {
+	counts []uint64 // 每个桶的数量，不累加
+	sum    float64
+	count  uint64
+}
+
+// Metrics 统计各阶段的耗时、token 数量和检索命中情况，实现了 Observer；
+// 同时实现了 http.Handler，按 Prometheus 文本格式导出指标
+type Metrics struct {
+	mu        sync.Mutex
+	durations map[string]*histogram         // 标签 -> 耗时
+	counters  map[string]map[string]float64 // 指标名称 -> 标签 -> 值
+}
+
+// NewMetrics 创建指标统计
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durations: make(map[string]*histogram),
+		counters:  make(map[string]map[string]float64),
+	}
+}
+
+func (m *Metrics) Start(ctx context.Context, _ Stage) context.Context {
+	return ctx
+}
+
+func (m *Metrics) End(_ context.Context, ev Event) {
+	status := "ok"
+	if ev.Err != nil {
+		status = "error"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observe(fmt.Sprintf(`stage=%q,status=%q`, ev.Stage, status), ev.Duration.Seconds())
+	if ev.Err != nil {
+		return
+	}
+
+	switch ev.Stage {
+	case StageGenerate:
+		m.add(MetricTokens, `type="prompt"`, attrInt(ev.Attrs, AttrPromptTokens))
+		m.add(MetricTokens, `type="completion"`, attrInt(ev.Attrs, AttrCompletionTokens))
+	case StageEmbed:
+		m.add(MetricEmbeddedTexts, "", attrInt(ev.Attrs, AttrTexts))
+	case StageStore:
+		m.add(MetricStoredChunks, "", attrInt(ev.Attrs, AttrChunks))
+	case StageRetrieve:
+		n := attrInt(ev.Attrs, AttrDocuments)
+		if n > 0 {
+			m.add(MetricRetrievals, `result="hit"`, 1)
+		} else {
+			m.add(MetricRetrievals, `result="miss"`, 1)
+		}
+		m.add(MetricRetrievedDocuments, "", n)
+	}
+}
+
+// observe 记录阶段耗时，需要在持有 m.mu 时调用
+func (m *Metrics) observe(labels string, v float64) {
+	h, ok := m.durations[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(DurationBuckets))}
+		m.durations[labels] = h
+	}
+	for i, b := range DurationBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// add 增加计数，需要在持有 m.mu 时调用
+func (m *Metrics) add(name, labels string, v int) {
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]float64)
+	}
+	m.counters[name][labels] += float64(v)
+}
+
+// WritePrometheus 按 Prometheus 文本格式写入所有指标，按名称和标签排序
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	if len(m.durations) > 0 {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", MetricStageDuration, metricHelp[MetricStageDuration], MetricStageDuration)
+		for _, labels := range slices.Sorted(maps.Keys(m.durations)) {
+			h := m.durations[labels]
+			var cumulative uint64
+			for i, b := range DurationBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(bw, "%s_bucket{%s,le=%q} %d\n", MetricStageDuration, labels, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", MetricStageDuration, labels, h.count)
+			fmt.Fprintf(bw, "%s_sum{%s} %g\n", MetricStageDuration, labels, h.sum)
+			fmt.Fprintf(bw, "%s_count{%s} %d\n", MetricStageDuration, labels, h.count)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.counters)) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, metricHelp[name], name)
+		for _, labels := range slices.Sorted(maps.Keys(m.counters[name])) {
+			if labels == "" {
+				fmt.Fprintf(bw, "%s %g\n", name, m.counters[name][labels])
+			} else {
+				fmt.Fprintf(bw, "%s{%s} %g\n", name, labels, m.counters[name][labels])
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP 导出指标，用于 Prometheus 抓取
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
+
+// attrInt 获取整数属性，不存在时为0
+func attrInt(attrs []slog.Attr, key string) int {
+	for _, a := range attrs {
+		if a.Key == key && a.Value.Kind() == slog.KindInt64 {
+			return int(a.Value.Int64())
+		}
+	}
+	return 0
+}
@@ -0,0 +1,224 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"maps"
+)
+
+type migrateOptions struct {
+	model     string // 为空时使用当前的向量模型
+	emb       *embeddings.EmbedderImpl
+	version   string
+	batchSize int
+	progress  func(done, total int)
+}
+
+type MigrateOption func(*migrateOptions)
+
+// WithMigrateEmbeddingModel 使用新的向量模型重新生成向量，迁移完成后 Client 使用该模型，默认使用当前模型
+func WithMigrateEmbeddingModel(model string) MigrateOption {
+	return func(o *migrateOptions) {
+		o.model = model
+	}
+}
+
+// WithMigrateBatchSize 每次写入新集合的分块数量，默认 100
+func WithMigrateBatchSize(n int) MigrateOption {
+	return func(o *migrateOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithMigrateProgress 每写入一批分块后回调
+func WithMigrateProgress(fn func(done, total int)) MigrateOption {
+	return func(o *migrateOptions) {
+		o.progress = fn
+	}
+}
+
+// MigrateResult 迁移结果
+type MigrateResult struct {
+	Chunks   int   // 迁移的分块数量
+	Previous Store // 迁移前的集合，确认无误后由调用方清理
+}
+
+// Migrate 蓝绿迁移：在 target 中创建集合和索引，分批重新生成所有分块的向量，完成后将 Client 切换到 target。
+// 复制期间添加或删除的文件会在切换前同步，同步和切换期间 AddDocuments、DeleteBySource 等写入会等待，
+// 切换后的读写都使用 target。
+// 迁移前的集合不会被删除或关闭，target 与当前集合共用连接时(Store.Collection)不要关闭 Previous，
+// 使用单独的连接时已添加的知识库仍使用之前的连接，关闭 Previous 前需要重新添加
+func (c *Client) Migrate(ctx context.Context, target Store, opts ...MigrateOption) (*MigrateResult, error) {
+	o := migrateOptions{batchSize: 100}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize < 1 {
+		return nil, errors.New("batchSize 必须大于0")
+	}
+
+	source := c.getBackend()
+	if source == nil {
+		return nil, errors.New("未设置mongodb store")
+	}
+	if target == nil || target == source {
+		return nil, errors.New("迁移的目标集合不能为空或与当前集合相同")
+	}
+	info := embeddingInfo{model: o.model}
+	if o.model == "" {
+		emb, err := c.GetEmbedder()
+		if err != nil {
+			return nil, err
+		}
+		model, version, err := c.EmbeddingModel(ctx)
+		if err != nil {
+			return nil, err
+		}
+		o.emb, o.model, o.version = emb, model, version
+		c.mu.RLock()
+		info = c.embInfo
+		c.mu.RUnlock()
+	} else {
+		emb, err := c.newEmbedder(o.model)
+		if err != nil {
+			return nil, err
+		}
+		version, err := c.modelVersion(ctx, o.model)
+		if err != nil {
+			return nil, err
+		}
+		o.emb, o.version = emb, version
+		info.version, info.resolved = version, true
+	}
+
+	if err := ensureStore(c.observe(ctx), target); err != nil {
+		return nil, err
+	}
+	if n, err := target.Count(ctx, bson.M{}); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, fmt.Errorf("目标集合已有 %d 个分块", n)
+	}
+
+	c.mu.RLock()
+	m := &migration{source: source, target: target, emb: c.cachedEmbedder(o.emb, cacheModel(o.model, o.version)), opts: o}
+	c.mu.RUnlock()
+	if err := m.copy(ctx, bson.M{}); err != nil {
+		return nil, err
+	}
+
+	// 阻塞写入，同步复制期间修改的文件后切换，正在进行的写入完成后才会开始同步
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.getBackend() != source {
+		return nil, errors.New("迁移期间集合已被切换")
+	}
+	if err := m.sync(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.backend != source {
+		return nil, errors.New("迁移期间集合已被切换")
+	}
+	c.backend, c.store, c.emb, c.embInfo = target, nil, o.emb, info
+	// target 使用单独的连接时，之前的连接随 Previous 交给调用方关闭
+	if c.conn != nil && !sameConn(c.conn, target) {
+		c.conn, c.connURI = nil, ""
+	}
+	return &MigrateResult{Chunks: m.done, Previous: source}, nil
+}
+
+type migration struct {
+	source, target Store
+	emb            embeddings.Embedder // 设置了缓存时为带缓存的 opts.emb
+	opts           migrateOptions
+	done           int
+}
+
+// copy 按 _id 分批读取 source 中符合 filter 的分块，重新生成向量后写入 target
+func (m *migration) copy(ctx context.Context, filter bson.M) error {
+	total, err := m.source.Count(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	vs := m.target.VectorStore(m.emb)
+	after := ""
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := m.source.Scan(ctx, filter, after, m.opts.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) < 1 {
+			return nil
+		}
+		after = batch[len(batch)-1].ID
+
+		docs := make([]schema.Document, 0, len(batch))
+		for _, v := range batch {
+			docs = append(docs, schema.Document{PageContent: v.PageContent, Metadata: maps.Clone(v.Metadata)})
+		}
+		setEmbeddingMetadata(docs, m.opts.model, m.opts.version)
+		if _, err = vs.AddDocuments(ctx, docs); err != nil {
+			return err
+		}
+
+		m.done += len(batch)
+		if m.opts.progress != nil {
+			m.opts.progress(m.done, int(total))
+		}
+	}
+}
+
+// sync 按文件对比 source 和 target，重新复制分块数量或修改时间不一致的文件，删除 source 中已不存在的文件
+func (m *migration) sync(ctx context.Context) error {
+	sources, err := m.source.Sources(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	targets, err := m.target.Sources(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	copied := make(map[string]Source, len(targets))
+	for _, s := range targets {
+		copied[s.Filename] = s
+	}
+	for _, s := range sources {
+		t, ok := copied[s.Filename]
+		delete(copied, s.Filename)
+		if ok && t == s {
+			continue
+		}
+
+		filter := bson.M{"metadata." + FilenameKey: s.Filename}
+		if ok {
+			n, err := m.target.DeleteMany(ctx, filter)
+			if err != nil {
+				return err
+			}
+			m.done -= int(n)
+		}
+		if err = m.copy(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	for filename := range copied {
+		n, err := m.target.DeleteMany(ctx, bson.M{"metadata." + FilenameKey: filename})
+		if err != nil {
+			return err
+		}
+		m.done -= int(n)
+	}
+	return nil
+}
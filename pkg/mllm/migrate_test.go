@@ -0,0 +1,155 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	srv := ollamatest.NewServer(ollamatest.WithModels(ollamatest.Model{Name: "embed-v2:latest", Digest: "0123456789abcdef", Dimensions: 32}))
+	t.Cleanup(srv.Close)
+	c, err := NewLLM("test-model", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := useMemoryStore(t, c, srv)
+
+	for _, f := range []string{
+		writeFile(t, "a.txt", "MongoDB Atlas 支持向量检索。"),
+		writeFile(t, "b.txt", "Ollama 可以在本地运行大模型。"),
+	} {
+		if _, err := c.AddDocuments(ctx, f); err != nil {
+			t.Fatalf("AddDocuments: %v", err)
+		}
+	}
+	total := countDocs(t, old)
+
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: 32, Similarity: FieldSimilarityCosine}
+	target := NewMemoryStore("vector_v2", "vector_index_v2", WithMemoryFields(field))
+
+	var progress int
+	res, err := c.Migrate(ctx, target, WithMigrateEmbeddingModel("embed-v2"), WithMigrateBatchSize(1), WithMigrateProgress(func(done, _ int) {
+		progress = done
+	}))
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if res.Chunks != total || progress != total || res.Previous != old || countDocs(t, target) != total {
+		t.Fatalf("Migrate = %+v, progress = %d, total = %d", res, progress, total)
+	}
+	if countDocs(t, old) != total {
+		t.Fatal("迁移不应修改原集合")
+	}
+
+	// 切换后使用新的集合和向量模型检索
+	docs, err := c.Search(ctx, "Ollama 本地", 1)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(docs) != 1 || !strings.Contains(docs[0].PageContent, "Ollama") {
+		t.Fatalf("Search = %+v", docs)
+	}
+	var embeds int
+	for _, req := range srv.RequestsTo("/api/embeddings") {
+		if req.Model == "embed-v2" {
+			embeds++
+		}
+	}
+	if embeds != total+1 {
+		t.Fatalf("新模型的向量请求 = %d", embeds)
+	}
+	if model, version, _ := c.EmbeddingModel(ctx); model != "embed-v2" || version != "0123456789ab" {
+		t.Fatalf("EmbeddingModel = %s, %s", model, version)
+	}
+	vecs, _ := target.Find(ctx, bson.M{"metadata." + EmbeddingModelKey: "embed-v2", "metadata." + EmbeddingVersionKey: "0123456789ab"}, "")
+	if len(vecs) != total {
+		t.Fatalf("新集合中的向量模型信息不正确：%d", len(vecs))
+	}
+
+	// 目标集合不为空时不迁移
+	if _, err = c.Migrate(ctx, old); err == nil {
+		t.Fatal("目标集合不为空时应返回错误")
+	}
+}
+
+func TestMigrateSync(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	old := useMemoryStore(t, c, srv)
+
+	a := writeFile(t, "a.txt", "文件 a 的内容。")
+	b := writeFile(t, "b.txt", "文件 b 的内容。")
+	for _, f := range []string{a, b} {
+		if _, err := c.AddDocuments(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 模拟复制完成后原集合中删除了 a 并添加了 d
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	target := NewMemoryStore("vector_v2", "vector_index", WithMemoryFields(field))
+	emb, _ := c.GetEmbedder()
+	m := &migration{source: old, target: target, emb: emb, opts: migrateOptions{model: "test-model", emb: emb, batchSize: 10}}
+	if err := ensureStore(ctx, target); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.copy(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.DeleteBySource(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	d := writeFile(t, "d.txt", "文件 d 的内容。")
+	if _, err := c.AddDocuments(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	sources, err := target.Sources(ctx, bson.M{})
+	if err != nil || len(sources) != 2 || sources[0].Filename != b || sources[1].Filename != d {
+		t.Fatalf("Sources = %+v, %v", sources, err)
+	}
+	if m.done != countDocs(t, target) {
+		t.Fatalf("done = %d, docs = %d", m.done, countDocs(t, target))
+	}
+}
+
+func TestMigrateWaitsForWriters(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	old := useMemoryStore(t, c, srv)
+	if _, err := c.AddDocuments(ctx, writeFile(t, "a.txt", "文件 a 的内容。")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟切换前取得原集合、切换开始后才写入的 AddDocuments
+	c.writeMu.RLock()
+	done := make(chan error, 1)
+	target := NewMemoryStore("vector_v2", "vector_index", WithMemoryFields(old.Fields()...))
+	go func() {
+		_, err := c.Migrate(ctx, target)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	emb, _ := c.GetEmbedder()
+	doc := schema.Document{PageContent: "文件 e 的内容。", Metadata: map[string]any{FilenameKey: "e.txt", UpdatedTime: "2026-01-01 00:00:00"}}
+	if _, err := old.VectorStore(emb).AddDocuments(ctx, []schema.Document{doc}); err != nil {
+		t.Fatal(err)
+	}
+	c.writeMu.RUnlock()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if n, err := target.Count(ctx, bson.M{"metadata." + FilenameKey: "e.txt"}); err != nil || n != 1 {
+		t.Fatalf("切换前的写入没有同步到新集合：%d, %v", n, err)
+	}
+}
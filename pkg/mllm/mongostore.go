@@ -3,9 +3,15 @@ package mllm
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/vectorstores"
+	"github.com/tmc/langchaingo/vectorstores/mongovector"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"slices"
+	"sort"
 	"time"
 )
 
@@ -13,6 +19,9 @@ type MongodbStore struct {
 	dbname   string
 	collname string
 	idx      string
+	path     string    // 向量字段
+	fields   []Field   // 创建索引使用的字段
+	wait     IndexWait // 等待索引可以查询的轮询配置
 	client   *mongo.Client
 	coll     *mongo.Collection
 }
@@ -41,18 +50,51 @@ const (
 )
 
 type Vector struct {
+	ID          string         `bson:"-"` // _id 的十六进制字符串
 	PageContent string         `bson:"page_content"`
 	Metadata    map[string]any `bson:"metadata"`
+	Embedding   []float32      `bson:"-"` // 只在 Scan 和 UpdateEmbeddings 中使用
 }
 
-func NewMongodbStore(uri, dbname, collname, idx string) (*MongodbStore, error) {
+// NewMongodbStore 连接 mongodb，fields 为空时使用默认的向量字段
+func NewMongodbStore(uri, dbname, collname, idx string, fields ...Field) (*MongodbStore, error) {
 	client, err := mongo.Connect(options.Client().ApplyURI(uri))
 	if err != nil {
 		return nil, err
 	}
 
+	if len(fields) < 1 {
+		fields = defaultFields()
+	}
 	coll := client.Database(dbname).Collection(collname)
-	return &MongodbStore{dbname: dbname, collname: collname, client: client, coll: coll, idx: idx}, nil
+	return &MongodbStore{dbname: dbname, collname: collname, client: client, coll: coll, idx: idx, path: vectorPath(fields), fields: fields, wait: DefaultIndexWait}, nil
+}
+
+func (m *MongodbStore) Index() string {
+	return m.idx
+}
+
+func (m *MongodbStore) Path() string {
+	return m.path
+}
+
+func (m *MongodbStore) Fields() []Field {
+	return m.fields
+}
+
+// Collection 同一个数据库中的其他集合，默认使用相同的索引配置
+func (m *MongodbStore) Collection(name string, opts ...CollectionOption) Store {
+	return m.Database(m.dbname, name, opts...)
+}
+
+// Database 其他数据库中的集合，与当前 Store 共用连接
+func (m *MongodbStore) Database(dbname, collname string, opts ...CollectionOption) *MongodbStore {
+	o := newCollectionOptions(m.idx, m.fields, opts)
+	res := *m
+	res.dbname, res.collname = dbname, collname
+	res.idx, res.fields, res.path = o.idx, o.fields, vectorPath(o.fields)
+	res.coll = m.client.Database(dbname).Collection(collname)
+	return &res
 }
 
 func (m *MongodbStore) Close(ctx context.Context) error {
@@ -64,22 +106,96 @@ func (m *MongodbStore) CreateCollection(ctx context.Context) error {
 }
 
 func (m *MongodbStore) SelectIndex(ctx context.Context, idx string) (bool, error) {
-	indexs := m.coll.SearchIndexes()
-
-	siOpts := options.SearchIndexes().SetName(idx).SetType(VectorSearchType)
-	cursor, err := indexs.List(ctx, siOpts)
+	status, err := m.IndexStatus(ctx, idx)
 	if err != nil {
 		return false, err
 	}
+	return status.Queryable, nil
+}
+
+// IndexStatus 查询 vectorSearch 索引的状态，索引不存在时状态为 DOES_NOT_EXIST
+func (m *MongodbStore) IndexStatus(ctx context.Context, idx string) (IndexStatus, error) {
+	info, err := m.indexInfo(ctx, idx)
+	return info.IndexStatus, err
+}
+
+// searchIndex $listSearchIndexes 返回的索引
+type searchIndex struct {
+	Name             string `bson:"name"`
+	Type             string `bson:"type"`
+	Status           string `bson:"status"`
+	Queryable        bool   `bson:"queryable"`
+	Message          string `bson:"message"`
+	LatestDefinition struct {
+		Fields []Field `bson:"fields"`
+	} `bson:"latestDefinition"`
+}
 
-	if cursor == nil || (cursor.Current == nil && !cursor.Next(ctx)) {
-		return false, nil
+func (s searchIndex) info() IndexInfo {
+	return IndexInfo{
+		IndexStatus: IndexStatus{Name: s.Name, Status: s.Status, Queryable: s.Queryable, Message: s.Message},
+		Type:        s.Type,
+		Fields:      s.LatestDefinition.Fields,
 	}
+}
 
-	name := cursor.Current.Lookup("name").StringValue()
-	queryable := cursor.Current.Lookup("queryable").Boolean()
+// listIndexes 获取集合中的搜索索引，name 为空时获取所有索引
+func (m *MongodbStore) listIndexes(ctx context.Context, name string) ([]IndexInfo, error) {
+	siOpts := options.SearchIndexes()
+	if name != "" {
+		siOpts.SetName(name)
+	}
+	cursor, err := m.coll.SearchIndexes().List(ctx, siOpts)
+	if err != nil {
+		return nil, err
+	}
 
-	return name == idx && queryable, nil
+	var list []searchIndex
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	infos := make([]IndexInfo, 0, len(list))
+	for _, s := range list {
+		infos = append(infos, s.info())
+	}
+	return infos, nil
+}
+
+// indexInfo 获取 vectorSearch 索引，索引不存在时状态为 DOES_NOT_EXIST
+func (m *MongodbStore) indexInfo(ctx context.Context, idx string) (IndexInfo, error) {
+	info := IndexInfo{IndexStatus: IndexStatus{Name: idx, Status: IndexStatusDoesNotExist}}
+	list, err := m.listIndexes(ctx, idx)
+	if err != nil {
+		return info, err
+	}
+	for _, v := range list {
+		if v.Name == idx && v.Type == VectorSearchType {
+			return v, nil
+		}
+	}
+	return info, nil
+}
+
+// ListIndexes 获取集合中所有搜索索引的状态及定义，按名称排序
+func (m *MongodbStore) ListIndexes(ctx context.Context) ([]IndexInfo, error) {
+	list, err := m.listIndexes(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// SetIndexWait 设置创建、更新、删除索引后等待的轮询配置，默认为 DefaultIndexWait
+func (m *MongodbStore) SetIndexWait(w IndexWait) {
+	m.wait = w
+}
+
+// WaitIndex 等待索引可以查询，索引失败、超时或 ctx 取消时返回 *IndexError
+func (m *MongodbStore) WaitIndex(ctx context.Context, idx string) error {
+	return waitIndex(ctx, m.wait, idx, func(ctx context.Context) (IndexStatus, error) {
+		return m.IndexStatus(ctx, idx)
+	}, indexQueryable)
 }
 
 func (m *MongodbStore) CreateIndex(ctx context.Context, idx string, fields []Field) error {
@@ -87,41 +203,227 @@ func (m *MongodbStore) CreateIndex(ctx context.Context, idx string, fields []Fie
 		return errors.New("索引字段不能为空")
 	}
 
-	indexs := m.coll.SearchIndexes()
 	// 设置创建的索引类型为 vectorSearch
 	siOpts := options.SearchIndexes().SetName(idx).SetType(VectorSearchType)
 
 	// 创建索引
-	searchName, err := indexs.CreateOne(ctx, mongo.SearchIndexModel{Definition: bson.M{"fields": fields}, Options: siOpts})
+	searchName, err := m.coll.SearchIndexes().CreateOne(ctx, mongo.SearchIndexModel{Definition: bson.M{"fields": fields}, Options: siOpts})
 	if err != nil {
 		return err
 	}
 
-	// 判断索引有没有创建好， 没有就等待5秒
-	var doc bson.Raw
-	for doc == nil {
-		cursor, err := indexs.List(ctx, options.SearchIndexes().SetName(searchName))
+	// 等待索引创建好
+	return m.WaitIndex(ctx, searchName)
+}
+
+// UpdateIndex 更新索引字段并等待新的定义构建完成，构建期间旧的定义仍然可以查询
+func (m *MongodbStore) UpdateIndex(ctx context.Context, idx string, fields []Field) error {
+	if len(fields) < 1 {
+		return errors.New("索引字段不能为空")
+	}
+	if err := m.coll.SearchIndexes().UpdateOne(ctx, idx, bson.M{"fields": fields}); err != nil {
+		return err
+	}
+
+	// 最新定义与更新的字段一致并且状态为 READY 时构建完成
+	var latest []Field
+	return waitIndex(ctx, m.wait, idx, func(ctx context.Context) (IndexStatus, error) {
+		info, err := m.indexInfo(ctx, idx)
+		latest = info.Fields
+		return info.IndexStatus, err
+	}, func(s IndexStatus) bool {
+		return s.Status == IndexStatusReady && slices.Equal(latest, fields)
+	})
+}
+
+// DropIndex 删除 vectorSearch 索引并等待删除完成
+func (m *MongodbStore) DropIndex(ctx context.Context, idx string) error {
+	if err := m.coll.SearchIndexes().DropOne(ctx, idx); err != nil {
+		return err
+	}
+	return waitIndex(ctx, m.wait, idx, func(ctx context.Context) (IndexStatus, error) {
+		s, err := m.IndexStatus(ctx, idx)
+		if s.Status == IndexStatusFailed {
+			s.Status = IndexStatusDeleting // 删除失败的索引时不返回 ErrIndexFailed
+		}
+		return s, err
+	}, indexDropped)
+}
+
+func (m *MongodbStore) SelectCollection(ctx context.Context) bool {
+	colls, _ := m.client.Database(m.dbname).ListCollectionNames(ctx, bson.M{"name": m.collname})
+	return len(colls) > 0
+}
+
+func (m *MongodbStore) Find(ctx context.Context, filter bson.M, sort string) ([]Vector, error) {
+	opts := options.Find()
+	if sort != "" {
+		opts.SetSort(bson.D{{Key: sort, Value: 1}})
+	}
+	cursor, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.decodeVectors(ctx, cursor, false)
+}
+
+// Scan 按 _id 升序获取 _id 大于 after 的分块，最多 limit 个，Embedding 为保存的向量
+func (m *MongodbStore) Scan(ctx context.Context, filter bson.M, after string, limit int) ([]Vector, error) {
+	if after != "" {
+		oid, err := bson.ObjectIDFromHex(after)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		filter = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": oid}}}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := m.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return m.decodeVectors(ctx, cursor, true)
+}
 
-		if !cursor.Next(ctx) {
-			break
+// decodeVectors 解析分块及 _id，embedding 为 true 时同时解析向量字段
+func (m *MongodbStore) decodeVectors(ctx context.Context, cursor *mongo.Cursor, embedding bool) ([]Vector, error) {
+	defer cursor.Close(ctx)
+
+	list := make([]Vector, 0, 16)
+	for cursor.Next(ctx) {
+		var v Vector
+		if err := cursor.Decode(&v); err != nil {
+			return nil, err
+		}
+		if oid, ok := cursor.Current.Lookup("_id").ObjectIDOK(); ok {
+			v.ID = oid.Hex()
 		}
+		if embedding {
+			if err := cursor.Current.Lookup(m.path).Unmarshal(&v.Embedding); err != nil {
+				return nil, fmt.Errorf("解析分块 %s 的向量失败：%w", v.ID, err)
+			}
+		}
+		list = append(list, v)
+	}
+	return list, cursor.Err()
+}
+
+// UpdateEmbeddings 按 ID 更新分块的向量和元数据
+func (m *MongodbStore) UpdateEmbeddings(ctx context.Context, vectors []Vector) error {
+	if len(vectors) < 1 {
+		return nil
+	}
 
-		name := cursor.Current.Lookup("name").StringValue()
-		queryable := cursor.Current.Lookup("queryable").Boolean()
-		if name == searchName && queryable {
-			doc = cursor.Current
-		} else {
-			time.Sleep(5 * time.Second)
+	models := make([]mongo.WriteModel, 0, len(vectors))
+	for _, v := range vectors {
+		oid, err := bson.ObjectIDFromHex(v.ID)
+		if err != nil {
+			return err
 		}
+		update := bson.M{"$set": bson.M{m.path: v.Embedding, "metadata": v.Metadata}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": oid}).SetUpdate(update))
 	}
+	_, err := m.coll.BulkWrite(ctx, models)
+	return err
+}
 
-	return nil
+func (m *MongodbStore) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return m.coll.CountDocuments(ctx, filter)
 }
 
-func (m *MongodbStore) SelectCollection(ctx context.Context) bool {
-	colls, _ := m.client.Database(m.dbname).ListCollectionNames(ctx, bson.M{"name": m.collname})
-	return len(colls) > 0
+func (m *MongodbStore) DeleteMany(ctx context.Context, filter bson.M) (int64, error) {
+	res, err := m.coll.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
+func (m *MongodbStore) Sources(ctx context.Context, filter bson.M) ([]Source, error) {
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{
+			"_id":          "$metadata." + FilenameKey,
+			"chunks":       bson.M{"$sum": 1},
+			"updated_time": bson.M{"$max": "$metadata." + UpdatedTime},
+		}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+	cursor, err := m.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Source, 0, 16)
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// VectorStore 基于 mongovector 的向量库，使用 $vectorSearch 检索
+func (m *MongodbStore) VectorStore(emb embeddings.Embedder) vectorstores.VectorStore {
+	store := mongovector.New(m.coll, emb, mongovector.WithIndex(m.idx), mongovector.WithPath(m.path))
+	return &store
+}
+
+// MongoEmbeddingCache 保存在 mongodb 集合中的向量缓存，可以在多个进程间共享
+type MongoEmbeddingCache struct {
+	coll *mongo.Collection
+}
+
+var _ EmbeddingStore = (*MongoEmbeddingCache)(nil)
+
+// EmbeddingCache 使用同一个数据库中的 collname 集合作为向量缓存，与当前 Store 共用连接
+func (m *MongodbStore) EmbeddingCache(collname string) *MongoEmbeddingCache {
+	return &MongoEmbeddingCache{coll: m.client.Database(m.dbname).Collection(collname)}
+}
+
+// usesConn 缓存是否使用 store 的连接
+func (c *MongoEmbeddingCache) usesConn(store Store) bool {
+	m, ok := store.(*MongodbStore)
+	return ok && c.coll.Database().Client() == m.client
+}
+
+// withConn 使用 m 的连接访问同名数据库中的同名集合
+func (c *MongoEmbeddingCache) withConn(m *MongodbStore) *MongoEmbeddingCache {
+	return &MongoEmbeddingCache{coll: m.client.Database(c.coll.Database().Name()).Collection(c.coll.Name())}
+}
+
+type cachedVector struct {
+	Key         string    `bson:"_id"`
+	Vector      []float32 `bson:"vector"`
+	CreatedTime time.Time `bson:"created_time"`
+}
+
+func (c *MongoEmbeddingCache) Get(ctx context.Context, keys []string) (map[string][]float32, error) {
+	cursor, err := c.coll.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+
+	var list []cachedVector
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	res := make(map[string][]float32, len(list))
+	for _, v := range list {
+		res[v.Key] = v.Vector
+	}
+	return res, nil
+}
+
+func (c *MongoEmbeddingCache) Set(ctx context.Context, vectors map[string][]float32) error {
+	if len(vectors) < 1 {
+		return nil
+	}
+
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(vectors))
+	for k, v := range vectors {
+		doc := cachedVector{Key: k, Vector: v, CreatedTime: now}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": k}).SetReplacement(doc).SetUpsert(true))
+	}
+	_, err := c.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
 }
@@ -0,0 +1,189 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"log/slog"
+	"time"
+)
+
+// Stage Client 处理过程中被观测的阶段，阶段可以嵌套，例：store 和 retrieve 中包含 embed
+type Stage string
+
+const (
+	StageLoad      Stage = "load"       // 读取文件
+	StageSplit     Stage = "split"      // 分割文本
+	StageEmbed     Stage = "embed"      // 调用模型生成向量，命中向量缓存时不会调用
+	StageStore     Stage = "store"      // 保存分块
+	StageRetrieve  Stage = "retrieve"   // 检索分块
+	StageGenerate  Stage = "generate"   // 调用模型生成内容
+	StageIndexWait Stage = "index_wait" // 等待向量索引创建、更新或删除完成
+)
+
+// Event 中属性的名称
+const (
+	AttrFilename         = "filename"          // load、split：文件名
+	AttrDocuments        = "documents"         // load：读取的文档数量；retrieve：检索到的分块数量
+	AttrChunks           = "chunks"            // split、store：分块数量
+	AttrTexts            = "texts"             // embed：文本数量
+	AttrModel            = "model"             // generate：模型名称
+	AttrPromptTokens     = "prompt_tokens"     // generate：输入 token 数量
+	AttrCompletionTokens = "completion_tokens" // generate：输出 token 数量
+	AttrIndex            = "index"             // index_wait：索引名称
+)
+
+// Event 阶段结束时的信息
+type Event struct {
+	Stage    Stage
+	Start    time.Time
+	Duration time.Duration
+	Err      error       // 阶段失败的原因，成功时为 nil
+	Attrs    []slog.Attr // 阶段的属性，名称见 AttrFilename 等
+}
+
+// Observer 观测 Client 各阶段的钩子，用于记录日志、指标和链路追踪
+type Observer interface {
+	// Start 阶段开始，返回的 ctx 会传递给阶段内的调用，用于关联嵌套的阶段
+	Start(ctx context.Context, stage Stage) context.Context
+	// End 阶段结束，ctx 为 Start 返回的 ctx
+	End(ctx context.Context, ev Event)
+}
+
+type observersKey struct{}
+
+// WithObservers 返回带观测钩子的 ctx，优先于 SetObservers 设置的钩子；
+// 也用于不经过 Client 的调用，例：直接通过 MongodbStore 管理索引
+func WithObservers(ctx context.Context, obs ...Observer) context.Context {
+	return context.WithValue(ctx, observersKey{}, obs)
+}
+
+// SetObservers 设置观测钩子，替换之前设置的钩子，为空时不观测
+func (c *Client) SetObservers(obs ...Observer) {
+	c.mu.Lock()
+	c.observers = obs
+	c.mu.Unlock()
+}
+
+// observe 把 Client 的观测钩子加入 ctx，ctx 中已有钩子时不替换，不能在持有 c.mu 时调用
+func (c *Client) observe(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(observersKey{}).([]Observer); ok {
+		return ctx
+	}
+	c.mu.RLock()
+	obs := c.observers
+	c.mu.RUnlock()
+	if len(obs) < 1 {
+		return ctx
+	}
+	return WithObservers(ctx, obs...)
+}
+
+// startStage 使用 Client 的观测钩子开始阶段
+func (c *Client) startStage(ctx context.Context, stage Stage, attrs ...slog.Attr) (context.Context, *span) {
+	return startStage(c.observe(ctx), stage, attrs...)
+}
+
+// span 一次执行中的阶段，为 nil 时表示没有观测钩子
+type span struct {
+	ctx context.Context
+	obs []Observer
+	ev  Event
+}
+
+// startStage 使用 ctx 中的观测钩子开始阶段，没有钩子时返回的 span 为 nil
+func startStage(ctx context.Context, stage Stage, attrs ...slog.Attr) (context.Context, *span) {
+	obs, _ := ctx.Value(observersKey{}).([]Observer)
+	if len(obs) < 1 {
+		return ctx, nil
+	}
+	s := &span{obs: obs, ev: Event{Stage: stage, Start: time.Now(), Attrs: attrs}}
+	for _, o := range obs {
+		ctx = o.Start(ctx, stage)
+	}
+	s.ctx = ctx
+	return ctx, s
+}
+
+// end 结束阶段，attrs 为阶段结束时才知道的属性
+func (s *span) end(err error, attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	s.ev.Duration = time.Since(s.ev.Start)
+	s.ev.Err = err
+	s.ev.Attrs = append(s.ev.Attrs, attrs...)
+	for _, o := range s.obs {
+		o.End(s.ctx, s.ev)
+	}
+}
+
+// GenerateContent 调用模型生成内容，记录 generate 阶段和 token 数量
+func (c *Client) GenerateContent(ctx context.Context, messages []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	ctx, s := c.startStage(ctx, StageGenerate, slog.String(AttrModel, c.model))
+	resp, err := c.LLM.GenerateContent(ctx, messages, opts...)
+	if err != nil {
+		s.end(err)
+		return nil, err
+	}
+
+	var prompt, completion int
+	for _, choice := range resp.Choices {
+		n, _ := choice.GenerationInfo["PromptTokens"].(int)
+		prompt += n
+		n, _ = choice.GenerationInfo["CompletionTokens"].(int)
+		completion += n
+	}
+	s.end(nil, slog.Int(AttrPromptTokens, prompt), slog.Int(AttrCompletionTokens, completion))
+	return resp, nil
+}
+
+// Call 使用 GenerateContent 生成内容，与 GenerateContent 一起实现 llms.Model
+func (c *Client) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, c, prompt, opts...)
+}
+
+// embedClient 调用模型生成向量时记录 embed 阶段
+type embedClient struct {
+	embeddings.EmbedderClient
+	c *Client
+}
+
+func (e embedClient) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, s := e.c.startStage(ctx, StageEmbed, slog.Int(AttrTexts, len(texts)))
+	vecs, err := e.EmbedderClient.CreateEmbedding(ctx, texts)
+	s.end(err)
+	return vecs, err
+}
+
+type logObserver struct {
+	logger *slog.Logger
+}
+
+// NewLogObserver 使用 slog 记录每个阶段的耗时和属性，成功时为 Debug 级别，失败时为 Error 级别；
+// logger 为 nil 时使用 slog.Default()
+func NewLogObserver(logger *slog.Logger) Observer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logObserver{logger: logger}
+}
+
+func (l logObserver) Start(ctx context.Context, _ Stage) context.Context {
+	return ctx
+}
+
+func (l logObserver) End(ctx context.Context, ev Event) {
+	level, msg := slog.LevelDebug, "阶段完成"
+	attrs := make([]slog.Attr, 0, len(ev.Attrs)+4)
+	attrs = append(attrs, slog.String("stage", string(ev.Stage)), slog.Duration("duration", ev.Duration))
+	attrs = append(attrs, ev.Attrs...)
+	if tenant, ok := TenantFromContext(ctx); ok {
+		attrs = append(attrs, slog.String("tenant", tenant))
+	}
+	if ev.Err != nil {
+		level, msg = slog.LevelError, "阶段失败"
+		attrs = append(attrs, slog.Any("error", ev.Err))
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
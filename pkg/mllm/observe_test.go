@@ -0,0 +1,186 @@
+package mllm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recorder 记录结束的阶段
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Start(ctx context.Context, _ Stage) context.Context {
+	return ctx
+}
+
+func (r *recorder) End(_ context.Context, ev Event) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+}
+
+func (r *recorder) find(stage Stage) (Event, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range r.events {
+		if ev.Stage == stage {
+			return ev, true
+		}
+	}
+	return Event{}, false
+}
+
+func TestObservers(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	rec, metrics := &recorder{}, NewMetrics()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c.SetObservers(rec, metrics, NewLogObserver(logger))
+	useMemoryStore(t, c, srv)
+
+	if _, err := c.AddDocuments(ctx, writeFile(t, "a.txt", "MongoDB Atlas 保存向量。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if _, err := c.Search(ctx, "MongoDB", 4); err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	srv.ReplyText("保存在 MongoDB Atlas")
+	if _, err := c.Chain(ctx, "向量保存在哪里？"); err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if _, err := c.Search(WithKnowledgeBases(ctx, "missing"), "MongoDB", 4); err == nil {
+		t.Fatal("知识库不存在时应返回错误")
+	}
+
+	for _, stage := range []Stage{StageLoad, StageSplit, StageEmbed, StageStore, StageRetrieve, StageGenerate} {
+		if _, ok := rec.find(stage); !ok {
+			t.Fatalf("没有记录 %s 阶段", stage)
+		}
+	}
+	if ev, _ := rec.find(StageStore); attrInt(ev.Attrs, AttrChunks) != 1 {
+		t.Fatalf("store attrs = %v", ev.Attrs)
+	}
+	if ev, _ := rec.find(StageGenerate); attrInt(ev.Attrs, AttrPromptTokens) < 1 || attrInt(ev.Attrs, AttrCompletionTokens) != 5 {
+		t.Fatalf("generate attrs = %v", ev.Attrs)
+	}
+
+	var out bytes.Buffer
+	if err := metrics.WritePrometheus(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`mllm_stage_duration_seconds_count{stage="store",status="ok"} 1`,
+		`mllm_stage_duration_seconds_count{stage="retrieve",status="error"} 1`,
+		`mllm_stage_duration_seconds_bucket{stage="embed",status="ok",le="+Inf"}`,
+		`mllm_tokens_total{type="completion"} 5`,
+		`mllm_retrievals_total{result="hit"} 2`,
+		`mllm_stored_chunks_total 1`,
+		"# TYPE mllm_stage_duration_seconds histogram",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("指标中没有 %s：\n%s", want, out.String())
+		}
+	}
+
+	logs := buf.String()
+	if !strings.Contains(logs, "stage=embed") || !strings.Contains(logs, "level=ERROR msg=阶段失败 stage=retrieve") {
+		t.Fatalf("logs = %s", logs)
+	}
+}
+
+func TestWaitIndexObserver(t *testing.T) {
+	rec := &recorder{}
+	ctx := WithObservers(context.Background(), rec)
+	err := waitIndex(ctx, IndexWait{}, "idx", func(ctx context.Context) (IndexStatus, error) {
+		return IndexStatus{Name: "idx", Status: IndexStatusFailed}, nil
+	}, indexQueryable)
+	if !errors.Is(err, ErrIndexFailed) {
+		t.Fatalf("err = %v", err)
+	}
+	ev, ok := rec.find(StageIndexWait)
+	if !ok || !errors.Is(ev.Err, ErrIndexFailed) || ev.Attrs[0].Value.String() != "idx" {
+		t.Fatalf("event = %+v", ev)
+	}
+}
+
+type fakeTracer struct {
+	embedded.Tracer
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	trace.Span
+	name   string
+	parent *fakeSpan
+	attrs  []attribute.KeyValue
+	status codes.Code
+	ended  bool
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent, _ := trace.SpanFromContext(ctx).(*fakeSpan)
+	s := &fakeSpan{Span: trace.SpanFromContext(context.Background()), name: name, parent: parent}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *fakeSpan) SetAttributes(kv ...attribute.KeyValue) { s.attrs = append(s.attrs, kv...) }
+func (s *fakeSpan) SetStatus(code codes.Code, _ string)    { s.status = code }
+func (s *fakeSpan) End(...trace.SpanEndOption)             { s.ended = true }
+
+func TestTraceObserver(t *testing.T) {
+	ctx := WithTenant(context.Background(), "acme")
+	c, srv := newTestClient(t)
+	useTenantStore(t, c, srv)
+	tracer := &fakeTracer{}
+	c.SetObservers(NewTraceObserver(tracer))
+
+	if _, err := c.AddDocuments(ctx, writeFile(t, "a.txt", "MongoDB Atlas 保存向量。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if _, err := c.Search(WithKnowledgeBases(ctx, "missing"), "MongoDB", 4); err == nil {
+		t.Fatal("知识库不存在时应返回错误")
+	}
+
+	spans := make(map[string]*fakeSpan)
+	for _, s := range tracer.spans {
+		if !s.ended {
+			t.Fatalf("span %s 没有结束", s.name)
+		}
+		spans[s.name] = s
+	}
+	embed, store := spans["mllm.embed"], spans["mllm.store"]
+	if embed == nil || store == nil || embed.parent != store {
+		t.Fatalf("embed 应为 store 的子 span：%+v", spans)
+	}
+	want := map[attribute.Key]attribute.Value{
+		"mllm.chunks": attribute.Int64Value(1),
+		"mllm.tenant": attribute.StringValue("acme"),
+	}
+	for _, kv := range store.attrs {
+		if v, ok := want[kv.Key]; ok && v == kv.Value {
+			delete(want, kv.Key)
+		}
+	}
+	if len(want) > 0 {
+		t.Fatalf("store attrs = %v", store.attrs)
+	}
+	if s := spans["mllm.retrieve"]; s == nil || s.status != codes.Error {
+		t.Fatalf("retrieve span = %+v", s)
+	}
+}
@@ -0,0 +1,427 @@
+// Package ollamatest 提供进程内的 Ollama 模拟服务，用于在没有 Ollama 的环境下测试。
+//
+// 支持 /api/chat、/api/generate、/api/embeddings、/api/embed 和 /api/tags 接口，
+// 向量由文本的词袋 hash 生成，相同文本的向量相同，相似文本的向量相似；
+// 对话按 Reply 设置的顺序返回，没有设置时返回 DefaultReply 的结果
+package ollamatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Response 模型返回的内容，Status 不为0时返回错误
+type Response struct {
+	Content   string
+	ToolCalls []ToolCall
+	Status    int
+	Error     string
+}
+
+// ToolCall 模型返回的工具调用
+type ToolCall struct {
+	Name      string
+	Arguments map[string]any
+}
+
+// Message 对话请求中的消息
+type Message struct {
+	Role     string `json:"role"`
+	Content  string `json:"content"`
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+// Request 记录收到的请求
+type Request struct {
+	Path     string
+	Model    string
+	Messages []Message // /api/chat 的消息，/api/generate 时为 prompt
+	Input    []string  // 向量化的文本
+	Format   string
+	Stream   bool
+	Tools    int // 请求中的工具数量
+}
+
+// Model /api/tags 返回的模型，Dimensions 不为0时该模型的向量使用此维度
+type Model struct {
+	Name       string
+	Digest     string
+	Dimensions int
+}
+
+type Server struct {
+	*httptest.Server
+	dims   int
+	models []Model
+
+	mu       sync.Mutex
+	replies  []Response
+	requests []Request
+
+	// DefaultReply 没有设置回复时使用，默认返回 "ok"
+	DefaultReply func(req Request) Response
+}
+
+type Option func(*Server)
+
+// WithDimensions 设置向量维度，默认 64
+func WithDimensions(dims int) Option {
+	return func(s *Server) {
+		s.dims = dims
+	}
+}
+
+// WithModels 设置 /api/tags 返回的模型
+func WithModels(models ...Model) Option {
+	return func(s *Server) {
+		s.models = append(s.models, models...)
+	}
+}
+
+// NewServer 启动模拟服务，使用完后需要调用 Close
+func NewServer(opts ...Option) *Server {
+	s := &Server{dims: 64, DefaultReply: func(Request) Response { return Response{Content: "ok"} }}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", s.chat)
+	mux.HandleFunc("POST /api/generate", s.generate)
+	mux.HandleFunc("POST /api/embeddings", s.embeddings)
+	mux.HandleFunc("POST /api/embed", s.embed)
+	mux.HandleFunc("GET /api/tags", s.tags)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Reply 按顺序设置对话的回复
+func (s *Server) Reply(replies ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies = append(s.replies, replies...)
+}
+
+// ReplyText 按顺序设置对话回复的文本
+func (s *Server) ReplyText(texts ...string) {
+	for _, t := range texts {
+		s.Reply(Response{Content: t})
+	}
+}
+
+// Requests 获取收到的请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// RequestsTo 获取指定接口收到的请求
+func (s *Server) RequestsTo(path string) []Request {
+	res := make([]Request, 0)
+	for _, r := range s.Requests() {
+		if r.Path == path {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// Dimensions 默认的向量维度，WithModels 中设置了维度的模型除外
+func (s *Server) Dimensions() int {
+	return s.dims
+}
+
+func (s *Server) record(req Request) Response {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	if len(s.replies) > 0 && req.Path != "/api/embed" && req.Path != "/api/embeddings" {
+		res := s.replies[0]
+		s.replies = s.replies[1:]
+		s.mu.Unlock()
+		return res
+	}
+	s.mu.Unlock()
+	return s.DefaultReply(req)
+}
+
+func (s *Server) chat(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model    string    `json:"model"`
+		Messages []Message `json:"messages"`
+		Format   any       `json:"format"`
+		Stream   *bool     `json:"stream"`
+		Tools    []any     `json:"tools"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	// ollama 默认流式返回
+	stream := body.Stream == nil || *body.Stream
+	req := Request{Path: r.URL.Path, Model: body.Model, Messages: body.Messages, Format: format(body.Format), Stream: stream, Tools: len(body.Tools)}
+	res := s.record(req)
+	if res.Status != 0 {
+		writeError(w, res)
+		return
+	}
+
+	calls := make([]map[string]any, 0, len(res.ToolCalls))
+	for _, c := range res.ToolCalls {
+		calls = append(calls, map[string]any{"function": map[string]any{"name": c.Name, "arguments": c.Arguments}})
+	}
+	message := func(content string, done bool) map[string]any {
+		msg := map[string]any{"role": "assistant", "content": content}
+		if done && len(calls) > 0 {
+			msg["tool_calls"] = calls
+		}
+		return map[string]any{"model": body.Model, "created_at": time.Now(), "message": msg, "done": done}
+	}
+	s.write(w, req, res.Content, message)
+}
+
+func (s *Server) generate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+		System string `json:"system"`
+		Format any    `json:"format"`
+		Stream *bool  `json:"stream"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	stream := body.Stream == nil || *body.Stream
+	msgs := []Message{{Role: "user", Content: body.Prompt}}
+	if body.System != "" {
+		msgs = append([]Message{{Role: "system", Content: body.System}}, msgs...)
+	}
+	req := Request{Path: r.URL.Path, Model: body.Model, Messages: msgs, Format: format(body.Format), Stream: stream}
+	res := s.record(req)
+	if res.Status != 0 {
+		writeError(w, res)
+		return
+	}
+
+	s.write(w, req, res.Content, func(content string, done bool) map[string]any {
+		return map[string]any{"model": body.Model, "created_at": time.Now(), "response": content, "done": done}
+	})
+}
+
+// write 返回结果，流式请求时按空白拆分为多个分块，最后返回 done 为 true 的分块
+func (s *Server) write(w http.ResponseWriter, req Request, content string, chunk func(content string, done bool) map[string]any) {
+	promptTokens := 0
+	for _, m := range req.Messages {
+		promptTokens += len(tokenize(m.Content))
+	}
+	// 流式请求时最后一个分块的内容为空，token 数量为完整回答的数量
+	final := func(part string) map[string]any {
+		res := chunk(part, true)
+		res["prompt_eval_count"] = promptTokens
+		res["eval_count"] = len(tokenize(content))
+		return res
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	if !req.Stream {
+		enc.Encode(final(content))
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for _, part := range splitChunks(content) {
+		enc.Encode(chunk(part, false))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	enc.Encode(final(""))
+}
+
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	s.record(Request{Path: r.URL.Path, Model: body.Model, Input: []string{body.Prompt}})
+	writeJSON(w, map[string]any{"embedding": Embed(body.Prompt, s.dimensions(body.Model))})
+}
+
+func (s *Server) embed(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if !decode(w, r, &body) {
+		return
+	}
+
+	var input []string
+	if err := json.Unmarshal(body.Input, &input); err != nil {
+		var text string
+		if err = json.Unmarshal(body.Input, &text); err != nil {
+			writeError(w, Response{Status: http.StatusBadRequest, Error: "input 格式错误"})
+			return
+		}
+		input = []string{text}
+	}
+
+	s.record(Request{Path: r.URL.Path, Model: body.Model, Input: input})
+	res := make([][]float32, 0, len(input))
+	tokens := 0
+	for _, text := range input {
+		res = append(res, Embed(text, s.dimensions(body.Model)))
+		tokens += len(tokenize(text))
+	}
+	writeJSON(w, map[string]any{"model": body.Model, "embeddings": res, "prompt_eval_count": tokens})
+}
+
+// SetModels 替换 /api/tags 返回的模型，用于模拟重新拉取模型后 digest 变化
+func (s *Server) SetModels(models ...Model) {
+	s.mu.Lock()
+	s.models = models
+	s.mu.Unlock()
+}
+
+func (s *Server) tags(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	list := s.models
+	s.mu.Unlock()
+	models := make([]map[string]any, 0, len(list))
+	for _, m := range list {
+		models = append(models, map[string]any{"name": m.Name, "model": m.Name, "digest": m.Digest})
+	}
+	writeJSON(w, map[string]any{"models": models})
+}
+
+// dimensions 模型的向量维度，没有指定 tag 时匹配 latest
+func (s *Server) dimensions(model string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.models {
+		if (m.Name == model || m.Name == model+":latest") && m.Dimensions > 0 {
+			return m.Dimensions
+		}
+	}
+	return s.dims
+}
+
+// Embed 生成确定的向量：文本拆分为词(中文按字)后 hash 到各个维度，再归一化
+func Embed(text string, dims int) []float32 {
+	vec := make([]float32, dims)
+	for _, token := range tokenize(text) {
+		h := fnv.New32a()
+		h.Write([]byte(token))
+		sum := h.Sum32()
+		sign := float32(1)
+		if sum&1 == 1 {
+			sign = -1
+		}
+		vec[int(sum>>1)%dims] += sign
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v * v)
+	}
+	if norm == 0 {
+		vec[0] = 1 // 空文本返回固定的单位向量
+		return vec
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] = float32(float64(vec[i]) / norm)
+	}
+	return vec
+}
+
+// tokenize 按非字母数字拆分并转为小写，中日韩文字单独作为一个词
+func tokenize(text string) []string {
+	res := make([]string, 0)
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			res = append(res, strings.ToLower(sb.String()))
+			sb.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			res = append(res, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return res
+}
+
+// splitChunks 按空白拆分为流式返回的分块，分块拼接后与原文相同
+func splitChunks(content string) []string {
+	res := make([]string, 0)
+	start := 0
+	for i, r := range content {
+		if unicode.IsSpace(r) && i > start {
+			res = append(res, content[start:i])
+			start = i
+		}
+	}
+	if start < len(content) {
+		res = append(res, content[start:])
+	}
+	return res
+}
+
+func format(v any) string {
+	switch f := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return f
+	default:
+		data, _ := json.Marshal(f)
+		return string(data)
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	data, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		writeError(w, Response{Status: http.StatusBadRequest, Error: fmt.Sprintf("请求参数错误：%s", err.Error())})
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, res Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(res.Status)
+	json.NewEncoder(w).Encode(map[string]any{"error": res.Error})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
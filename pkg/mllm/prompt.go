@@ -0,0 +1,385 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/prompts"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PromptSpec 提示词模板，从 yaml 文件中加载，例：
+//
+//	name: translate
+//	version: 1
+//	format: go-template
+//	input_variables: [inputLang, outputLang, input]
+//	system: 你是一个翻译人员，只翻译文本，不进行解释
+//	human: "将此文本从{{.inputLang}}转换为{{.outputLang}}:\n{{.input}}"
+type PromptSpec struct {
+	Name           string                 `yaml:"name"`
+	Version        int                    `yaml:"version"`
+	Description    string                 `yaml:"description"`
+	Format         prompts.TemplateFormat `yaml:"format"` // go-template 或 f-string，默认 go-template
+	InputVariables []string               `yaml:"input_variables"`
+	System         string                 `yaml:"system"`
+	Human          string                 `yaml:"human"`
+	FewShot        *FewShotSpec           `yaml:"few_shot"`
+}
+
+// FewShotSpec 少样本示例，格式化后的示例添加在 human 消息前
+type FewShotSpec struct {
+	Template  string              `yaml:"template"`  // 单个示例的模板
+	Variables []string            `yaml:"variables"` // 示例模板中的变量
+	Separator string              `yaml:"separator"` // 示例之间的分隔符，默认换行
+	Examples  []map[string]string `yaml:"examples"`
+}
+
+// FewShotSelector 根据输入选择少样本示例，ExampleSelector 实现该接口
+type FewShotSelector interface {
+	Select(ctx context.Context, input map[string]string) ([]map[string]string, error)
+}
+
+type promptOptions struct {
+	selector FewShotSelector
+	callOpts []llms.CallOption
+}
+
+type PromptOption func(*promptOptions)
+
+// WithPromptSelector 根据输入动态选择少样本示例，替代模板中的 Examples，只作用于本次调用
+func WithPromptSelector(selector FewShotSelector) PromptOption {
+	return func(o *promptOptions) {
+		o.selector = selector
+	}
+}
+
+// WithPromptCallOptions ChatWithPrompt 调用模型时使用的参数
+func WithPromptCallOptions(opts ...llms.CallOption) PromptOption {
+	return func(o *promptOptions) {
+		o.callOpts = append(o.callOpts, opts...)
+	}
+}
+
+func newPromptOptions(opts []PromptOption) promptOptions {
+	var o promptOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+var _ prompts.FormatPrompter = (*PromptSpec)(nil)
+
+// Ref 模板的引用名称，例：translate@1
+func (p *PromptSpec) Ref() string {
+	return p.Name + "@" + strconv.Itoa(p.Version)
+}
+
+// Validate 校验模板格式，声明的变量必须在模板中使用，模板中使用的变量必须声明
+func (p *PromptSpec) Validate() error {
+	if p.Name == "" || strings.Contains(p.Name, "@") {
+		return errors.New("name 不能为空且不能包含@")
+	}
+	if p.Version < 1 {
+		return fmt.Errorf("%s version 必须大于0", p.Name)
+	}
+	if p.Format == "" {
+		p.Format = prompts.TemplateFormatGoTemplate
+	}
+	if p.Human == "" {
+		return fmt.Errorf("%s human 不能为空", p.Ref())
+	}
+
+	for _, tmpl := range []string{p.System, p.Human} {
+		if err := prompts.CheckValidTemplate(tmpl, p.Format, p.InputVariables); err != nil {
+			return fmt.Errorf("%s 模板错误：%w", p.Ref(), err)
+		}
+	}
+	for _, v := range p.InputVariables {
+		if !usesVariable(p.System, p.Format, v) && !usesVariable(p.Human, p.Format, v) {
+			return fmt.Errorf("%s 声明的变量 %s 未使用", p.Ref(), v)
+		}
+	}
+
+	if fs := p.FewShot; fs != nil {
+		if fs.Separator == "" {
+			fs.Separator = "\n"
+		}
+		if err := prompts.CheckValidTemplate(fs.Template, p.Format, fs.Variables); err != nil {
+			return fmt.Errorf("%s 示例模板错误：%w", p.Ref(), err)
+		}
+		for i, example := range fs.Examples {
+			for _, v := range fs.Variables {
+				if _, ok := example[v]; !ok {
+					return fmt.Errorf("%s 第%d个示例缺少变量 %s", p.Ref(), i+1, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// usesVariable 判断模板中是否使用了变量
+func usesVariable(tmpl string, format prompts.TemplateFormat, name string) bool {
+	var pattern string
+	switch format {
+	case prompts.TemplateFormatFString:
+		pattern = `\{` + regexp.QuoteMeta(name) + `\}`
+	case prompts.TemplateFormatJinja2:
+		pattern = `\{\{-?\s*` + regexp.QuoteMeta(name) + `\b`
+	default:
+		pattern = `\.` + regexp.QuoteMeta(name) + `\b`
+	}
+	return regexp.MustCompile(pattern).MatchString(tmpl)
+}
+
+// GetInputVariables 实现 prompts.FormatPrompter
+func (p *PromptSpec) GetInputVariables() []string {
+	return p.InputVariables
+}
+
+// FormatPrompt 实现 prompts.FormatPrompter，可以在 chains.NewLLMChain 中使用
+func (p *PromptSpec) FormatPrompt(values map[string]any) (llms.PromptValue, error) {
+	system, human, err := p.render(context.Background(), values, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make(prompts.ChatPromptValue, 0, 2)
+	if system != "" {
+		msgs = append(msgs, llms.SystemChatMessage{Content: system})
+	}
+	return append(msgs, llms.HumanChatMessage{Content: human}), nil
+}
+
+// Messages 格式化模板，返回对话使用的消息，ctx 用于选择少样本示例，选择失败时返回错误
+func (p *PromptSpec) Messages(ctx context.Context, values map[string]any, opts ...PromptOption) ([]llms.MessageContent, error) {
+	system, human, err := p.render(ctx, values, newPromptOptions(opts).selector)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]llms.MessageContent, 0, 2)
+	if system != "" {
+		msgs = append(msgs, llms.TextParts(llms.ChatMessageTypeSystem, system))
+	}
+	return append(msgs, llms.TextParts(llms.ChatMessageTypeHuman, human)), nil
+}
+
+func (p *PromptSpec) render(ctx context.Context, values map[string]any, selector FewShotSelector) (string, string, error) {
+	for _, v := range p.InputVariables {
+		if _, ok := values[v]; !ok {
+			return "", "", fmt.Errorf("%s 缺少变量 %s", p.Ref(), v)
+		}
+	}
+
+	var (
+		system, human string
+		err           error
+	)
+	if p.System != "" {
+		if system, err = prompts.RenderTemplate(p.System, p.Format, values); err != nil {
+			return "", "", err
+		}
+	}
+	if human, err = prompts.RenderTemplate(p.Human, p.Format, values); err != nil {
+		return "", "", err
+	}
+
+	examples, err := p.renderExamples(ctx, values, selector)
+	if err != nil {
+		return "", "", err
+	}
+	if examples != "" {
+		human = examples + p.FewShot.Separator + human
+	}
+	return system, human, nil
+}
+
+// renderExamples 格式化少样本示例，selector 不为空时使用 selector 选择的示例
+func (p *PromptSpec) renderExamples(ctx context.Context, values map[string]any, selector FewShotSelector) (string, error) {
+	fs := p.FewShot
+	if fs == nil {
+		return "", nil
+	}
+
+	examples := fs.Examples
+	if selector != nil {
+		input := make(map[string]string, len(values))
+		for k, v := range values {
+			input[k] = fmt.Sprint(v)
+		}
+		var err error
+		if examples, err = selector.Select(ctx, input); err != nil {
+			return "", fmt.Errorf("%s 选择示例失败：%w", p.Ref(), err)
+		}
+	}
+
+	res := make([]string, 0, len(examples))
+	for _, example := range examples {
+		vals := make(map[string]any, len(example))
+		for k, v := range example {
+			vals[k] = v
+		}
+		s, err := prompts.RenderTemplate(fs.Template, p.Format, vals)
+		if err != nil {
+			return "", err
+		}
+		res = append(res, s)
+	}
+	return strings.Join(res, fs.Separator), nil
+}
+
+// PromptRegistry 提示词模板库，同一个名称可以有多个版本
+type PromptRegistry struct {
+	mu      sync.RWMutex
+	prompts map[string][]*PromptSpec // 按版本升序排列
+}
+
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{prompts: make(map[string][]*PromptSpec)}
+}
+
+// LoadPrompts 加载目录下所有 .yaml/.yml 模板文件，每个文件一个模板
+func LoadPrompts(dir string) (*PromptRegistry, error) {
+	r := NewPromptRegistry()
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		spec := &PromptSpec{}
+		if err = yaml.Unmarshal(data, spec); err != nil {
+			return fmt.Errorf("解析模板 %s 失败：%w", path, err)
+		}
+		if err = r.Register(spec); err != nil {
+			return fmt.Errorf("加载模板 %s 失败：%w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register 校验并注册模板，同名同版本的模板不能重复注册
+func (r *PromptRegistry) Register(spec *PromptSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := r.prompts[spec.Name]
+	for _, p := range list {
+		if p.Version == spec.Version {
+			return fmt.Errorf("模板 %s 已存在", spec.Ref())
+		}
+	}
+	list = append(list, spec)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	r.prompts[spec.Name] = list
+	return nil
+}
+
+// Get 获取模板，ref 为 name 时返回最新版本，为 name@version 时返回指定版本
+func (r *PromptRegistry) Get(ref string) (*PromptSpec, error) {
+	name, version, hasVersion := strings.Cut(ref, "@")
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := r.prompts[name]
+	if len(list) < 1 {
+		return nil, fmt.Errorf("模板 %s 不存在", name)
+	}
+	if !hasVersion {
+		return list[len(list)-1], nil
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return nil, fmt.Errorf("模板版本格式错误：%s", ref)
+	}
+	for _, p := range list {
+		if p.Version == v {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("模板 %s 不存在", ref)
+}
+
+// List 获取所有模板，按名称和版本排序
+func (r *PromptRegistry) List() []*PromptSpec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.prompts))
+	for name := range r.prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make([]*PromptSpec, 0, len(names))
+	for _, name := range names {
+		res = append(res, r.prompts[name]...)
+	}
+	return res
+}
+
+// SetPrompts 设置提示词模板库
+func (c *Client) SetPrompts(r *PromptRegistry) {
+	c.prompts = r
+}
+
+// Prompt 从模板库中获取模板
+func (c *Client) Prompt(ref string) (*PromptSpec, error) {
+	if c.prompts == nil {
+		return nil, errors.New("未设置提示词模板库")
+	}
+	return c.prompts.Get(ref)
+}
+
+// ChatWithPrompt 使用模板库中的模板进行对话，k 大于0时检索知识库
+func (c *Client) ChatWithPrompt(ctx context.Context, ref string, values map[string]any, k int, opts ...PromptOption) (*ChatResult, error) {
+	p, err := c.Prompt(ref)
+	if err != nil {
+		return nil, err
+	}
+	o := newPromptOptions(opts)
+	msgs, err := p.Messages(ctx, values, WithPromptSelector(o.selector))
+	if err != nil {
+		return nil, err
+	}
+	return c.Chat(ctx, msgs, k, o.callOpts...)
+}
+
+// ChainWithPrompt 使用模板库中的模板基于向量库回答问题，模板需要声明 context 和 question 变量，检索范围与 Search 相同
+func (c *Client) ChainWithPrompt(ctx context.Context, ref, query string, opts ...chains.ChainCallOption) (map[string]any, error) {
+	p, err := c.Prompt(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	combine := chains.NewStuffDocuments(chains.NewLLMChain(c, p))
+	qa := chains.NewRetrievalQA(combine, retriever{client: c, k: 10})
+	qa.ReturnSourceDocuments = true
+	return qa.Call(ctx, map[string]interface{}{"query": query}, opts...)
+}
@@ -0,0 +1,67 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fixedSelector 总是返回第一个示例
+type fixedSelector struct {
+	examples []map[string]string
+}
+
+func (s fixedSelector) Select(context.Context, map[string]string) ([]map[string]string, error) {
+	return s.examples[:1], nil
+}
+
+// errSelector 选择示例失败
+type errSelector struct{}
+
+func (errSelector) Select(context.Context, map[string]string) ([]map[string]string, error) {
+	return nil, errors.New("向量库不可用")
+}
+
+func TestChatWithPromptSelector(t *testing.T) {
+	c, srv := newTestClient(t)
+	examples := []map[string]string{{"input": "a", "output": "甲"}, {"input": "b", "output": "乙"}}
+	r := NewPromptRegistry()
+	err := r.Register(&PromptSpec{
+		Name:           "translate",
+		Version:        1,
+		InputVariables: []string{"input"},
+		Human:          "{{.input}}",
+		FewShot:        &FewShotSpec{Template: "{{.input}}={{.output}}", Variables: []string{"input", "output"}, Separator: "\n", Examples: examples},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetPrompts(r)
+	srv.ReplyText("ok", "ok")
+
+	ctx := context.Background()
+	if _, err = c.ChatWithPrompt(ctx, "translate", map[string]any{"input": "c"}, 0, WithPromptSelector(fixedSelector{examples})); err != nil {
+		t.Fatalf("ChatWithPrompt: %v", err)
+	}
+	// 选择器只作用于本次调用，之后的调用仍使用模板中的示例
+	if _, err = c.ChatWithPrompt(ctx, "translate", map[string]any{"input": "c"}, 0); err != nil {
+		t.Fatalf("ChatWithPrompt: %v", err)
+	}
+
+	// 选择示例失败时返回错误，不发送没有示例的请求
+	if _, err = c.ChatWithPrompt(ctx, "translate", map[string]any{"input": "c"}, 0, WithPromptSelector(errSelector{})); err == nil {
+		t.Fatal("选择示例失败时应返回错误")
+	}
+
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) != 2 {
+		t.Fatalf("chat requests = %d", len(reqs))
+	}
+	for i, want := range []string{"a=甲\nc", "a=甲\nb=乙\nc"} {
+		msgs := reqs[i].Messages
+		if got := msgs[len(msgs)-1].Content; !strings.Contains(got, want) {
+			t.Fatalf("request %d = %q, want %q", i, got, want)
+		}
+	}
+}
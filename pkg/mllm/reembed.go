@@ -0,0 +1,291 @@
+package mllm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/schema"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// 写入到分块元数据中的向量模型信息，模型变化后通过 ReEmbed 重新生成向量
+var (
+	EmbeddingModelKey   = "embedding_model"   // 向量模型名称
+	EmbeddingVersionKey = "embedding_version" // 向量模型版本，ollama 中模型 digest 的前12位
+)
+
+type embeddingInfo struct {
+	model    string // 为空时使用 Client 的模型
+	version  string
+	resolved bool // 已查询过版本
+}
+
+// SetEmbeddingModel 使用单独的向量模型，切换后已保存的分块需要通过 ReEmbed 重新生成向量
+func (c *Client) SetEmbeddingModel(model string) error {
+	emb, err := c.newEmbedder(model)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emb, c.store, c.embInfo = emb, nil, embeddingInfo{model: model}
+	return nil
+}
+
+// newEmbedder 使用同一个 ollama 服务中的 model 生成向量
+func (c *Client) newEmbedder(model string) (*embeddings.EmbedderImpl, error) {
+	llm, err := ollama.New(append(slices.Clone(c.opts), ollama.WithModel(model))...)
+	if err != nil {
+		return nil, err
+	}
+	return embeddings.NewEmbedder(embedClient{EmbedderClient: llm, c: c})
+}
+
+// EmbeddingModel 当前使用的向量模型名称和版本
+func (c *Client) EmbeddingModel(ctx context.Context) (model, version string, err error) {
+	c.mu.RLock()
+	info := c.embInfo
+	c.mu.RUnlock()
+	if info.model == "" {
+		info.model = c.model
+	}
+	if info.resolved {
+		return info.model, info.version, nil
+	}
+
+	version, err = c.modelVersion(ctx, info.model)
+	if err != nil {
+		return "", "", err
+	}
+
+	c.mu.Lock()
+	// 期间切换了模型时不缓存
+	if c.embInfo.model == "" || c.embInfo.model == info.model {
+		c.embInfo = embeddingInfo{model: c.embInfo.model, version: version, resolved: true}
+	}
+	c.mu.Unlock()
+	return info.model, version, nil
+}
+
+// modelVersion 通过 /api/tags 获取模型的 digest，模型不在列表中时返回空字符串
+func (c *Client) modelVersion(ctx context.Context, model string) (string, error) {
+	var res struct {
+		Models []struct {
+			Name   string `json:"name"`
+			Model  string `json:"model"`
+			Digest string `json:"digest"`
+		} `json:"models"`
+	}
+	if err := c.requestOllama(ctx, http.MethodGet, "/api/tags", nil, &res); err != nil {
+		return "", err
+	}
+
+	for _, m := range res.Models {
+		for _, name := range []string{m.Name, m.Model} {
+			if name == model || name == model+":latest" {
+				return m.Digest[:min(12, len(m.Digest))], nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// setEmbeddingMetadata 在分块元数据中写入向量模型信息
+func setEmbeddingMetadata(docs []schema.Document, model, version string) {
+	for i := range docs {
+		if docs[i].Metadata == nil {
+			docs[i].Metadata = make(map[string]any, 2)
+		}
+		docs[i].Metadata[EmbeddingModelKey] = model
+		docs[i].Metadata[EmbeddingVersionKey] = version
+	}
+}
+
+// staleFilter 向量模型与 model、version 不一致的分块
+func staleFilter(model, version string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"metadata." + EmbeddingModelKey: bson.M{"$ne": model}},
+		bson.M{"metadata." + EmbeddingVersionKey: bson.M{"$ne": version}},
+	}}
+}
+
+// ReEmbedCheckpoint 重新生成向量的进度，LastID 之前的分块已处理
+type ReEmbedCheckpoint struct {
+	Model   string `json:"model"`
+	Version string `json:"version"`
+	LastID  string `json:"last_id"`
+	Done    int    `json:"done"` // 已处理的分块数量
+}
+
+type reEmbedOptions struct {
+	batchSize  int
+	checkpoint string
+	progress   func(ReEmbedCheckpoint)
+}
+
+type ReEmbedOption func(*reEmbedOptions)
+
+// WithReEmbedBatchSize 每批重新生成向量的分块数量，默认 100
+func WithReEmbedBatchSize(n int) ReEmbedOption {
+	return func(o *reEmbedOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithReEmbedCheckpoint 每批处理完成后将进度保存到文件，再次执行时从文件中的进度继续，全部完成后删除文件
+func WithReEmbedCheckpoint(filename string) ReEmbedOption {
+	return func(o *reEmbedOptions) {
+		o.checkpoint = filename
+	}
+}
+
+// WithReEmbedProgress 每批处理完成后回调
+func WithReEmbedProgress(fn func(ReEmbedCheckpoint)) ReEmbedOption {
+	return func(o *reEmbedOptions) {
+		o.progress = fn
+	}
+}
+
+// ReEmbed 使用当前的向量模型重新生成模型名称或版本不一致的分块的向量，按 _id 顺序分批写回原集合。
+// ctx 通过 WithKnowledgeBases 指定了一个知识库时处理该知识库的集合，未指定时处理当前集合；
+// 已处理的分块会写入新的模型信息，中断后再次执行只处理剩余的分块；
+// 向量维度与索引不一致时返回错误，需要使用 Migrate 迁移到新的集合和索引
+func (c *Client) ReEmbed(ctx context.Context, opts ...ReEmbedOption) (*ReEmbedCheckpoint, error) {
+	o := reEmbedOptions{batchSize: 100}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize < 1 {
+		return nil, errors.New("batchSize 必须大于0")
+	}
+
+	backend, err := c.backendFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	model, version, err := c.EmbeddingModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	cached := c.cachedEmbedder(emb, cacheModel(model, version))
+	c.mu.RUnlock()
+
+	cp := &ReEmbedCheckpoint{Model: model, Version: version}
+	if o.checkpoint != "" {
+		saved, err := loadCheckpoint(o.checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		// 模型变化后重新开始
+		if saved != nil && saved.Model == model && saved.Version == version {
+			cp = saved
+		}
+	}
+
+	dims := 0
+	for _, f := range backend.Fields() {
+		if f.Type == FieldTypeVector {
+			dims = f.NumDimensions
+		}
+	}
+
+	filter := staleFilter(model, version)
+	for {
+		if err = ctx.Err(); err != nil {
+			return cp, err
+		}
+		list, err := backend.Scan(ctx, filter, cp.LastID, o.batchSize)
+		if err != nil {
+			return cp, err
+		}
+		if len(list) < 1 {
+			break
+		}
+
+		texts := make([]string, 0, len(list))
+		for _, v := range list {
+			texts = append(texts, v.PageContent)
+		}
+		vecs, err := cached.EmbedDocuments(ctx, texts)
+		if err != nil {
+			return cp, err
+		}
+		if len(vecs) != len(list) {
+			return cp, errors.New("向量数量与分块数量不一致")
+		}
+		for i := range list {
+			if dims > 0 && len(vecs[i]) != dims {
+				return cp, fmt.Errorf("向量维度 %d 与索引维度 %d 不一致，请使用 Migrate 迁移到新的索引", len(vecs[i]), dims)
+			}
+			if list[i].Metadata == nil {
+				list[i].Metadata = make(map[string]any, 2)
+			}
+			list[i].Metadata[EmbeddingModelKey] = model
+			list[i].Metadata[EmbeddingVersionKey] = version
+			list[i].Embedding = vecs[i]
+		}
+		if err = backend.UpdateEmbeddings(ctx, list); err != nil {
+			return cp, err
+		}
+
+		cp.LastID = list[len(list)-1].ID
+		cp.Done += len(list)
+		if o.checkpoint != "" {
+			if err = saveCheckpoint(o.checkpoint, cp); err != nil {
+				return cp, err
+			}
+		}
+		if o.progress != nil {
+			o.progress(*cp)
+		}
+	}
+
+	if o.checkpoint != "" {
+		if err = os.Remove(o.checkpoint); err != nil && !os.IsNotExist(err) {
+			return cp, err
+		}
+	}
+	return cp, nil
+}
+
+// loadCheckpoint 读取进度文件，文件不存在时返回 nil
+func loadCheckpoint(filename string) (*ReEmbedCheckpoint, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp ReEmbedCheckpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("解析进度文件 %s 失败：%w", filename, err)
+	}
+	return &cp, nil
+}
+
+// saveCheckpoint 先写入临时文件再重命名，避免中断时进度文件不完整
+func saveCheckpoint(filename string, cp *ReEmbedCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,176 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/textsplitter"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"os"
+	"path/filepath"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+)
+
+func TestReEmbed(t *testing.T) {
+	ctx := context.Background()
+	srv := ollamatest.NewServer(ollamatest.WithModels(
+		ollamatest.Model{Name: "test-model:latest", Digest: "aaaaaaaaaaaaaaaa"},
+		ollamatest.Model{Name: "embed-v2:latest", Digest: "bbbbbbbbbbbbbbbb"},
+		ollamatest.Model{Name: "embed-wide:latest", Digest: "cccccccccccccccc", Dimensions: 32},
+	))
+	t.Cleanup(srv.Close)
+	c, err := NewLLM("test-model", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := useMemoryStore(t, c, srv)
+	c.SetTextSplitter(NewChineseSplitter(textsplitter.WithChunkSize(8), textsplitter.WithChunkOverlap(0)))
+
+	filename := writeFile(t, "doc.txt", "第一段内容。\n\n第二段内容。\n\n第三段内容。")
+	if _, err = c.AddDocuments(ctx, filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	total := countDocs(t, store)
+	if n, _ := store.Count(ctx, bson.M{"metadata." + EmbeddingModelKey: "test-model", "metadata." + EmbeddingVersionKey: "aaaaaaaaaaaa"}); int(n) != total {
+		t.Fatalf("分块中缺少向量模型信息：%d/%d", n, total)
+	}
+
+	if err = c.SetEmbeddingModel("embed-v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 处理第一批后中断
+	checkpoint := filepath.Join(t.TempDir(), "reembed.json")
+	cctx, cancel := context.WithCancel(ctx)
+	_, err = c.ReEmbed(cctx, WithReEmbedBatchSize(1), WithReEmbedCheckpoint(checkpoint), WithReEmbedProgress(func(ReEmbedCheckpoint) {
+		cancel()
+	}))
+	if err == nil {
+		t.Fatal("取消后 ReEmbed 应返回错误")
+	}
+	saved, err := loadCheckpoint(checkpoint)
+	if err != nil || saved == nil || saved.Done != 1 || saved.Model != "embed-v2" {
+		t.Fatalf("checkpoint = %+v, %v", saved, err)
+	}
+
+	// 从进度继续
+	cp, err := c.ReEmbed(ctx, WithReEmbedBatchSize(1), WithReEmbedCheckpoint(checkpoint))
+	if err != nil {
+		t.Fatalf("ReEmbed: %v", err)
+	}
+	if cp.Done != total {
+		t.Fatalf("Done = %d, want %d", cp.Done, total)
+	}
+	if _, err = os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Fatal("完成后应删除进度文件")
+	}
+	if n, _ := store.Count(ctx, bson.M{"metadata." + EmbeddingModelKey: "embed-v2", "metadata." + EmbeddingVersionKey: "bbbbbbbbbbbb"}); int(n) != total {
+		t.Fatalf("重新生成向量的分块 = %d/%d", n, total)
+	}
+	var embeds int
+	for _, req := range srv.RequestsTo("/api/embeddings") {
+		if req.Model == "embed-v2" {
+			embeds++
+		}
+	}
+	if embeds != total {
+		t.Fatalf("embed-v2 向量请求 = %d, want %d", embeds, total)
+	}
+
+	// 没有需要处理的分块
+	if cp, err = c.ReEmbed(ctx); err != nil || cp.Done != 0 {
+		t.Fatalf("ReEmbed again = %+v, %v", cp, err)
+	}
+
+	// 维度不一致时需要迁移
+	if err = c.SetEmbeddingModel("embed-wide"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ReEmbed(ctx); err == nil || !strings.Contains(err.Error(), "Migrate") {
+		t.Fatalf("维度不一致时 ReEmbed = %v", err)
+	}
+}
+
+func TestReEmbedAfterRepull(t *testing.T) {
+	ctx := context.Background()
+	srv := ollamatest.NewServer(ollamatest.WithModels(ollamatest.Model{Name: "test-model:latest", Digest: "aaaaaaaaaaaaaaaa"}))
+	t.Cleanup(srv.Close)
+	lru := NewLRUCache(100)
+	newClient := func() *Client {
+		c, err := NewLLM("test-model", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetEmbeddingCache(lru)
+		return c
+	}
+
+	c := newClient()
+	store := useMemoryStore(t, c, srv)
+	if _, err := c.AddDocuments(ctx, writeFile(t, "doc.txt", "MongoDB Atlas 支持向量检索。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	before := len(srv.RequestsTo("/api/embeddings"))
+
+	// 同名模型重新拉取后 digest 变化，缓存中旧版本的向量不能再使用
+	srv.SetModels(ollamatest.Model{Name: "test-model:latest", Digest: "dddddddddddddddd"})
+	c = newClient()
+	if err := c.SetStore(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := c.ReEmbed(ctx)
+	if err != nil {
+		t.Fatalf("ReEmbed: %v", err)
+	}
+	total := countDocs(t, store)
+	if cp.Done != total || cp.Version != "dddddddddddd" {
+		t.Fatalf("checkpoint = %+v", cp)
+	}
+	if n := len(srv.RequestsTo("/api/embeddings")) - before; n != total {
+		t.Fatalf("重新生成向量的请求 = %d, want %d", n, total)
+	}
+}
+
+func TestReEmbedKnowledgeBase(t *testing.T) {
+	ctx := context.Background()
+	srv := ollamatest.NewServer(ollamatest.WithModels(
+		ollamatest.Model{Name: "test-model:latest", Digest: "aaaaaaaaaaaaaaaa"},
+		ollamatest.Model{Name: "embed-v2:latest", Digest: "bbbbbbbbbbbbbbbb"},
+	))
+	t.Cleanup(srv.Close)
+	c, err := NewLLM("test-model", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := useMemoryStore(t, c, srv)
+	if err = c.AddKnowledgeBase(ctx, "kb", "kb"); err != nil {
+		t.Fatal(err)
+	}
+	kb := WithKnowledgeBases(ctx, "kb")
+	if _, err = c.AddDocuments(ctx, writeFile(t, "default.txt", "默认集合的内容。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if _, err = c.AddDocuments(kb, writeFile(t, "kb.txt", "知识库的内容。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+
+	if err = c.SetEmbeddingModel("embed-v2"); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := c.ReEmbed(kb)
+	if err != nil || cp.Done != 1 {
+		t.Fatalf("ReEmbed = %+v, %v", cp, err)
+	}
+	// 只处理指定的知识库
+	stale := bson.M{"metadata." + EmbeddingModelKey: "test-model"}
+	if n, _ := store.Count(ctx, stale); n != 1 {
+		t.Fatalf("默认集合中旧模型的分块 = %d, want 1", n)
+	}
+	kbStore, _ := c.knowledgeBase("kb")
+	if n, _ := kbStore.(*MemoryStore).Count(ctx, stale); n != 0 {
+		t.Fatalf("知识库中旧模型的分块 = %d, want 0", n)
+	}
+	if _, err = c.ReEmbed(WithKnowledgeBases(ctx, "kb", "missing")); err == nil {
+		t.Fatal("指定多个知识库时 ReEmbed 应返回错误")
+	}
+}
@@ -0,0 +1,247 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tmc/langchaingo/chains"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RouterPrompt 使用模型选择知识库时的系统提示词，第一个 %s 为知识库列表，%d 为最多选择的数量
+var RouterPrompt = "你负责为用户的问题选择需要检索的知识库。知识库列表（名称：说明）：\n%s\n" +
+	"只选择与问题相关的知识库，最多选择 %d 个，按相关程度排序；都不相关时返回空数组。"
+
+// Route 可以被路由的知识库
+type Route struct {
+	KnowledgeBase string // 通过 AddKnowledgeBase 添加的知识库名称
+	Description   string // 知识库的内容说明，用于模型选择或计算相似度
+}
+
+type routeChoice struct {
+	KnowledgeBases []string `json:"knowledge_bases" describe:"需要检索的知识库名称"`
+}
+
+// Router 在检索前为每个问题选择需要查询的知识库，默认由模型根据知识库说明选择，
+// 也可以通过 WithRouterEmbedding 使用问题与知识库说明的向量相似度选择
+type Router struct {
+	client    *Client
+	routes    []Route
+	embedding bool    // 使用向量相似度选择
+	threshold float32 // 向量相似度阈值
+	maxRoutes int
+	fallback  []string
+	retries   int
+
+	mu    sync.Mutex
+	model string      // vecs 对应的向量模型和版本，变化后重新生成
+	vecs  [][]float32 // 知识库说明的向量
+}
+
+type RouterOption func(*Router)
+
+// WithRouterEmbedding 使用问题与知识库说明的余弦相似度选择知识库，选择相似度不低于 threshold 的知识库，不调用模型
+func WithRouterEmbedding(threshold float32) RouterOption {
+	return func(r *Router) {
+		r.embedding, r.threshold = true, threshold
+	}
+}
+
+// WithRouterMaxRoutes 每个问题最多查询的知识库数量，默认 2
+func WithRouterMaxRoutes(n int) RouterOption {
+	return func(r *Router) {
+		r.maxRoutes = n
+	}
+}
+
+// WithRouterFallback 没有选中任何知识库时查询的知识库，默认查询所有知识库
+func WithRouterFallback(names ...string) RouterOption {
+	return func(r *Router) {
+		r.fallback = names
+	}
+}
+
+// WithRouterRetries 模型输出格式错误时的重试次数，默认 1
+func WithRouterRetries(n int) RouterOption {
+	return func(r *Router) {
+		r.retries = n
+	}
+}
+
+// NewRouter 创建路由，routes 中的知识库需要已经通过 AddKnowledgeBase 添加
+func (c *Client) NewRouter(routes []Route, opts ...RouterOption) (*Router, error) {
+	if len(routes) < 1 {
+		return nil, errors.New("知识库列表不能为空")
+	}
+	r := &Router{client: c, routes: routes, maxRoutes: 2, retries: 1}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.maxRoutes < 1 {
+		return nil, errors.New("maxRoutes 必须大于0")
+	}
+
+	names := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if route.KnowledgeBase == "" || route.Description == "" {
+			return nil, errors.New("知识库名称和说明不能为空")
+		}
+		if slices.Contains(names, route.KnowledgeBase) {
+			return nil, fmt.Errorf("知识库 %s 重复", route.KnowledgeBase)
+		}
+		if _, err := c.knowledgeBase(route.KnowledgeBase); err != nil {
+			return nil, err
+		}
+		names = append(names, route.KnowledgeBase)
+	}
+	if r.fallback == nil {
+		r.fallback = names
+	}
+	for _, name := range r.fallback {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("fallback 中的知识库 %s 不在路由中", name)
+		}
+	}
+	return r, nil
+}
+
+// Route 为问题选择需要查询的知识库，没有选中时返回 fallback
+func (r *Router) Route(ctx context.Context, question string) ([]string, error) {
+	var (
+		names []string
+		err   error
+	)
+	if r.embedding {
+		names, err = r.routeByEmbedding(ctx, question)
+	} else {
+		names, err = r.routeByLLM(ctx, question)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(names) < 1 {
+		return slices.Clone(r.fallback), nil
+	}
+	return names, nil
+}
+
+// Chain 选择知识库后基于选中的知识库回答问题，返回结果中 knowledge_bases 为查询的知识库
+func (r *Router) Chain(ctx context.Context, question string, opts ...chains.ChainCallOption) (map[string]any, error) {
+	names, err := r.Route(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.client.Chain(WithKnowledgeBases(ctx, names...), question, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res["knowledge_bases"] = names
+	return res, nil
+}
+
+// routeByLLM 由模型根据知识库说明选择，忽略不存在的知识库名称
+func (r *Router) routeByLLM(ctx context.Context, question string) ([]string, error) {
+	lines := make([]string, 0, len(r.routes))
+	for _, route := range r.routes {
+		lines = append(lines, fmt.Sprintf("- %s：%s", route.KnowledgeBase, route.Description))
+	}
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, fmt.Sprintf(RouterPrompt, strings.Join(lines, "\n"), r.maxRoutes)),
+		llms.TextParts(llms.ChatMessageTypeHuman, question),
+	}
+	choice, err := Structured[routeChoice](ctx, r.client, messages, r.retries, llms.WithTemperature(0))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, r.maxRoutes)
+	for _, name := range choice.KnowledgeBases {
+		if len(names) >= r.maxRoutes {
+			break
+		}
+		if r.hasRoute(name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// routeByEmbedding 按问题与知识库说明的相似度从高到低选择
+func (r *Router) routeByEmbedding(ctx context.Context, question string) ([]string, error) {
+	c := r.client
+	model, err := c.embeddingCacheModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	emb, err := c.embedder()
+	cached := c.cachedEmbedder(emb, model)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	vecs, err := r.descriptionVectors(ctx, cached, model)
+	if err != nil {
+		return nil, err
+	}
+	vec, err := cached.EmbedQuery(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		name  string
+		score float64
+	}
+	list := make([]scored, 0, len(vecs))
+	for i, v := range vecs {
+		if score := cosine(vec, v); score >= float64(r.threshold) {
+			list = append(list, scored{name: r.routes[i].KnowledgeBase, score: score})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].score > list[j].score })
+
+	names := make([]string, 0, r.maxRoutes)
+	for _, s := range list[:min(r.maxRoutes, len(list))] {
+		names = append(names, s.name)
+	}
+	return names, nil
+}
+
+// descriptionVectors 知识库说明的向量，第一次使用或向量模型变化时生成
+func (r *Router) descriptionVectors(ctx context.Context, emb embeddings.Embedder, model string) ([][]float32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.vecs != nil && r.model == model {
+		return r.vecs, nil
+	}
+
+	texts := make([]string, 0, len(r.routes))
+	for _, route := range r.routes {
+		texts = append(texts, route.Description)
+	}
+	vecs, err := emb.EmbedDocuments(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vecs) != len(texts) {
+		return nil, errors.New("向量数量与知识库数量不一致")
+	}
+	r.vecs, r.model = vecs, model
+	return vecs, nil
+}
+
+func (r *Router) hasRoute(name string) bool {
+	for _, route := range r.routes {
+		if route.KnowledgeBase == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package mllm
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+)
+
+// newRouterClient 添加两个知识库，每个知识库保存一个文件
+func newRouterClient(t *testing.T) (*Client, *ollamatest.Server) {
+	t.Helper()
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	for name, content := range map[string]string{
+		"product-docs": "产品使用 MongoDB Atlas 保存向量。",
+		"runbooks":     "服务故障时先重启 ollama。",
+	} {
+		if err := c.AddKnowledgeBase(ctx, name, ""); err != nil {
+			t.Fatalf("AddKnowledgeBase: %v", err)
+		}
+		if _, err := c.AddDocuments(WithKnowledgeBases(ctx, name), writeFile(t, name+".txt", content)); err != nil {
+			t.Fatalf("AddDocuments: %v", err)
+		}
+	}
+	return c, srv
+}
+
+var testRoutes = []Route{
+	{KnowledgeBase: "product-docs", Description: "产品功能和向量存储"},
+	{KnowledgeBase: "runbooks", Description: "服务故障处理"},
+}
+
+func TestRouterLLM(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newRouterClient(t)
+	router, err := c.NewRouter(testRoutes)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	// 忽略不存在的知识库
+	srv.ReplyText(`{"knowledge_bases":["runbooks","unknown"]}`, "重启 ollama")
+	res, err := router.Chain(ctx, "服务挂了怎么办？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if got := res["knowledge_bases"].([]string); !slices.Equal(got, []string{"runbooks"}) || res["text"] != "重启 ollama" {
+		t.Fatalf("res = %+v", res)
+	}
+
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) != 2 || !strings.Contains(reqs[0].Messages[0].Content, "runbooks：服务故障处理") {
+		t.Fatalf("chat requests = %+v", reqs)
+	}
+	if !strings.Contains(reqs[1].Messages[0].Content, "重启 ollama") || strings.Contains(reqs[1].Messages[0].Content, "MongoDB") {
+		t.Fatalf("只应检索 runbooks：%s", reqs[1].Messages[0].Content)
+	}
+
+	// 都不相关时使用 fallback
+	srv.ReplyText(`{"knowledge_bases":[]}`)
+	if names, err := router.Route(ctx, "今天天气怎么样"); err != nil || len(names) != 2 {
+		t.Fatalf("Route = %v, %v", names, err)
+	}
+}
+
+func TestRouterEmbedding(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newRouterClient(t)
+	router, err := c.NewRouter(testRoutes, WithRouterEmbedding(0.3), WithRouterMaxRoutes(1), WithRouterFallback("product-docs"))
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	for question, want := range map[string]string{
+		"服务故障怎么处理": "runbooks",
+		"向量存储在哪里":  "product-docs",
+		"hello":    "product-docs",
+	} {
+		names, err := router.Route(ctx, question)
+		if err != nil {
+			t.Fatalf("Route: %v", err)
+		}
+		if !slices.Equal(names, []string{want}) {
+			t.Fatalf("Route(%q) = %v", question, names)
+		}
+	}
+	if n := len(srv.RequestsTo("/api/chat")); n != 0 {
+		t.Fatalf("chat requests = %d", n)
+	}
+}
+
+func TestNewRouterValidate(t *testing.T) {
+	c, _ := newRouterClient(t)
+	for _, routes := range [][]Route{
+		nil,
+		{{KnowledgeBase: "missing", Description: "不存在"}},
+		{{KnowledgeBase: "runbooks"}},
+		{testRoutes[1], testRoutes[1]},
+	} {
+		if _, err := c.NewRouter(routes); err == nil {
+			t.Fatalf("NewRouter(%+v) 应返回错误", routes)
+		}
+	}
+	if _, err := c.NewRouter(testRoutes, WithRouterFallback("missing")); err == nil {
+		t.Fatal("fallback 不在路由中时应返回错误")
+	}
+}
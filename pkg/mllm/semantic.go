@@ -0,0 +1,205 @@
+package mllm
+
+import (
+	"context"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/textsplitter"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SemanticSplitter 基于向量相似度的文本分割器
+// 先将文本拆分为句子，对相邻句子进行向量化，在相似度明显下降（话题变化）的位置进行切分
+type SemanticSplitter struct {
+	ctx        context.Context
+	embedder   embeddings.Embedder
+	percentile float64 // 相邻句子距离超过该百分位时进行切分
+	bufferSize int     // 向量化时前后各合并的句子数量，减少单句带来的噪声
+	maxSize    int     // 分块最大字符数，超过时强制切分
+	minSize    int     // 分块最小字符数，小于时不切分
+
+	second textsplitter.TextSplitter // 拆分超过 maxSize 的句子
+}
+
+var _ textsplitter.TextSplitter = (*SemanticSplitter)(nil)
+
+type SemanticOption func(*SemanticSplitter)
+
+// WithBreakpointPercentile 设置切分阈值百分位，取值 0-100，值越大分块越少
+func WithBreakpointPercentile(p float64) SemanticOption {
+	return func(s *SemanticSplitter) {
+		s.percentile = p
+	}
+}
+
+// WithBufferSize 设置向量化时前后合并的句子数量
+func WithBufferSize(n int) SemanticOption {
+	return func(s *SemanticSplitter) {
+		s.bufferSize = n
+	}
+}
+
+// WithMaxChunkSize 设置分块最大字符数
+func WithMaxChunkSize(n int) SemanticOption {
+	return func(s *SemanticSplitter) {
+		s.maxSize = n
+	}
+}
+
+// WithMinChunkSize 设置分块最小字符数
+func WithMinChunkSize(n int) SemanticOption {
+	return func(s *SemanticSplitter) {
+		s.minSize = n
+	}
+}
+
+// WithSemanticContext 设置 SplitText 向量化请求使用的 context，创建时确定，不随每次调用变化；
+// 需要按调用取消时使用 SplitTextContext
+func WithSemanticContext(ctx context.Context) SemanticOption {
+	return func(s *SemanticSplitter) {
+		s.ctx = ctx
+	}
+}
+
+func NewSemanticSplitter(embedder embeddings.Embedder, opts ...SemanticOption) *SemanticSplitter {
+	s := &SemanticSplitter{
+		ctx:        context.Background(),
+		embedder:   embedder,
+		percentile: 95,
+		bufferSize: 1,
+		maxSize:    1024,
+		minSize:    64,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.second = textsplitter.NewRecursiveCharacter(
+		textsplitter.WithSeparators(append([]string{"\n\n", "\n"}, ChineseSeparators...)),
+		textsplitter.WithChunkSize(s.maxSize),
+		textsplitter.WithChunkOverlap(0),
+		textsplitter.WithLenFunc(utf8.RuneCountInString),
+	)
+	return s
+}
+
+// NewSemanticSplitter 使用当前模型的向量化能力创建语义分割器
+func (c *Client) NewSemanticSplitter(opts ...SemanticOption) (*SemanticSplitter, error) {
+	emb, err := c.GetEmbedder()
+	if err != nil {
+		return nil, err
+	}
+	return NewSemanticSplitter(emb, opts...), nil
+}
+
+// SplitText 实现 textsplitter.TextSplitter，使用 WithSemanticContext 设置的 context
+func (s *SemanticSplitter) SplitText(text string) ([]string, error) {
+	return s.SplitTextContext(s.ctx, text)
+}
+
+// SplitTextContext 使用 ctx 进行向量化并分割文本，超过 maxSize 的句子先按分隔符拆分，分块不超过 maxSize
+func (s *SemanticSplitter) SplitTextContext(ctx context.Context, text string) ([]string, error) {
+	sentences := make([]string, 0, 32)
+	for _, sentence := range splitSentences(text) {
+		if utf8.RuneCountInString(sentence) <= s.maxSize {
+			sentences = append(sentences, sentence)
+			continue
+		}
+		parts, err := s.second.SplitText(sentence)
+		if err != nil {
+			return nil, err
+		}
+		sentences = append(sentences, parts...)
+	}
+	if len(sentences) < 2 {
+		return trimChunks(sentences), nil
+	}
+
+	// 合并前后句子后进行向量化
+	groups := make([]string, len(sentences))
+	for i := range sentences {
+		lo, hi := max(0, i-s.bufferSize), min(len(sentences), i+s.bufferSize+1)
+		groups[i] = strings.Join(sentences[lo:hi], "")
+	}
+	vectors, err := s.embedder.EmbedDocuments(ctx, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	// 计算相邻句子的距离，距离越大话题变化越明显
+	distances := make([]float64, len(vectors)-1)
+	for i := range distances {
+		distances[i] = 1 - cosine(vectors[i], vectors[i+1])
+	}
+	threshold := percentile(distances, s.percentile)
+
+	chunks := make([]string, 0, len(sentences)/4+1)
+	var (
+		buf  strings.Builder
+		size int
+	)
+	for i, sentence := range sentences {
+		n := utf8.RuneCountInString(sentence)
+		if size > 0 && size+n > s.maxSize {
+			chunks = append(chunks, buf.String())
+			buf.Reset()
+			size = 0
+		}
+		buf.WriteString(sentence)
+		size += n
+
+		if i < len(distances) && distances[i] > 0 && distances[i] >= threshold && size >= s.minSize {
+			chunks = append(chunks, buf.String())
+			buf.Reset()
+			size = 0
+		}
+	}
+	if size > 0 {
+		chunks = append(chunks, buf.String())
+	}
+	return trimChunks(chunks), nil
+}
+
+func trimChunks(chunks []string) []string {
+	res := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		if c = strings.TrimSpace(c); c != "" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// percentile 计算百分位数，使用线性插值
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	pos := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	if lo < 0 {
+		return sorted[0]
+	}
+	if hi >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
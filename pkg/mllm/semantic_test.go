@@ -0,0 +1,65 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSemanticSplitter(t *testing.T) {
+	c, _ := newTestClient(t)
+	s, err := c.NewSemanticSplitter(WithBufferSize(0), WithMinChunkSize(1), WithBreakpointPercentile(80))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 话题变化的位置切分
+	text := "apple banana fruit. apple fruit sweet. apple banana sweet. car engine wheel. car wheel speed. car engine speed."
+	chunks, err := s.SplitText(text)
+	if err != nil {
+		t.Fatalf("SplitText: %v", err)
+	}
+	want := []string{"apple banana fruit. apple fruit sweet. apple banana sweet.", "car engine wheel. car wheel speed. car engine speed."}
+	if len(chunks) != len(want) || chunks[0] != want[0] || chunks[1] != want[1] {
+		t.Fatalf("chunks = %q, want %q", chunks, want)
+	}
+}
+
+func TestSemanticSplitterMaxSize(t *testing.T) {
+	c, _ := newTestClient(t)
+	s, err := c.NewSemanticSplitter(WithMaxChunkSize(50), WithMinChunkSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有句子结尾标点的超长句子按分隔符拆分
+	text := "短句。" + strings.Repeat("很长的句子没有标点，", 30) + "结尾。"
+	chunks, err := s.SplitText(text)
+	if err != nil {
+		t.Fatalf("SplitText: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("chunks = %q", chunks)
+	}
+	for _, chunk := range chunks {
+		if n := utf8.RuneCountInString(chunk); n > 50 {
+			t.Fatalf("分块长度 %d 超过 50：%q", n, chunk)
+		}
+	}
+}
+
+func TestSemanticSplitterContext(t *testing.T) {
+	c, _ := newTestClient(t)
+	s, err := c.NewSemanticSplitter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = s.SplitTextContext(ctx, "第一句。第二句。"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
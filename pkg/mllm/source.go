@@ -0,0 +1,114 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"reflect"
+	"strings"
+)
+
+// Source 已保存到向量库中的文件
+type Source struct {
+	Filename    string `bson:"_id" json:"filename"`
+	Chunks      int    `bson:"chunks" json:"chunks"`             // 分块数量
+	UpdatedTime string `bson:"updated_time" json:"updated_time"` // 文件最后修改时间
+}
+
+// Stats 向量库统计信息
+type Stats struct {
+	Sources     int    `json:"sources"`      // 文件数量
+	Chunks      int    `json:"chunks"`       // 分块总数
+	UpdatedTime string `json:"updated_time"` // 所有文件中最后的修改时间
+}
+
+// ListSources 获取向量库中的所有文件，按文件名排序，ctx 中有租户时只返回该租户的文件，指定了知识库时返回该知识库的文件
+func (c *Client) ListSources(ctx context.Context) ([]Source, error) {
+	backend, err := c.backendFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := c.scopeFilter(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.Sources(ctx, filter)
+}
+
+// DeleteBySource 删除文件对应的所有分块，返回删除的分块数量
+func (c *Client) DeleteBySource(ctx context.Context, filenames ...string) (int64, error) {
+	if len(filenames) < 1 {
+		return 0, errors.New("文件名不能为空")
+	}
+	return c.DeleteByFilter(ctx, map[string]any{FilenameKey: bson.M{"$in": filenames}})
+}
+
+// DeleteByFilter 根据元数据删除分块，filter 的键为元数据字段名，例：{"filename": "docs/txt/1.txt"}
+// 返回删除的分块数量，ctx 中有租户时只删除该租户的分块，指定了知识库时只删除该知识库的分块
+func (c *Client) DeleteByFilter(ctx context.Context, filter map[string]any) (int64, error) {
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	backend, err := c.backendFor(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(filter) < 1 {
+		return 0, errors.New("删除条件不能为空")
+	}
+	scoped, err := c.scopeFilter(ctx, metadataFilter(filter))
+	if err != nil {
+		return 0, err
+	}
+
+	return backend.DeleteMany(ctx, scoped)
+}
+
+// Stats 获取向量库统计信息，ctx 中有租户时只统计该租户
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	sources, err := c.ListSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Sources: len(sources)}
+	for _, s := range sources {
+		stats.Chunks += s.Chunks
+		if s.UpdatedTime > stats.UpdatedTime {
+			stats.UpdatedTime = s.UpdatedTime
+		}
+	}
+	return stats, nil
+}
+
+// metadataFilter 为元数据字段添加 metadata. 前缀，以 $ 开头的操作符保持不变，$and、$or、$nor 中的条件同样添加前缀
+func metadataFilter(filter map[string]any) bson.M {
+	res := make(bson.M, len(filter))
+	for k, v := range filter {
+		switch {
+		case k == "$and" || k == "$or" || k == "$nor":
+			v = metadataFilters(v)
+		case !strings.HasPrefix(k, "$") && !strings.HasPrefix(k, "metadata."):
+			k = "metadata." + k
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// metadataFilters 为逻辑操作符中的每个条件添加前缀，无法识别的类型保持不变，由数据库返回错误
+func metadataFilters(v any) any {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return v
+	}
+	res := make(bson.A, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		f := rv.Index(i).Interface()
+		if sub, err := toFilter(f); err == nil {
+			f = metadataFilter(sub)
+		}
+		res = append(res, f)
+	}
+	return res
+}
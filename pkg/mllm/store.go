@@ -0,0 +1,174 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Store 保存分块的集合，MongodbStore 使用 MongoDB Atlas，MemoryStore 在内存中模拟，用于测试
+// filter 使用 MongoDB 查询语法，字段为文档中的路径，例：{"metadata.filename": {"$in": files}}
+type Store interface {
+	Index() string   // 向量索引名称
+	Path() string    // 向量字段
+	Fields() []Field // 创建向量索引使用的字段
+
+	SelectCollection(ctx context.Context) bool
+	CreateCollection(ctx context.Context) error
+	SelectIndex(ctx context.Context, idx string) (bool, error) // 索引存在并且可以查询
+	CreateIndex(ctx context.Context, idx string, fields []Field) error
+	UpdateIndex(ctx context.Context, idx string, fields []Field) error // 等待新的定义构建完成
+	DropIndex(ctx context.Context, idx string) error
+	ListIndexes(ctx context.Context) ([]IndexInfo, error)
+
+	// Find 查询分块，sort 不为空时按该字段升序排列
+	Find(ctx context.Context, filter bson.M, sort string) ([]Vector, error)
+	// Scan 按 _id 升序分批获取 _id 大于 after 的分块，after 为空时从头开始，返回的 Embedding 为保存的向量
+	Scan(ctx context.Context, filter bson.M, after string, limit int) ([]Vector, error)
+	// UpdateEmbeddings 按 ID 更新分块的向量和元数据
+	UpdateEmbeddings(ctx context.Context, vectors []Vector) error
+	Count(ctx context.Context, filter bson.M) (int64, error)
+	DeleteMany(ctx context.Context, filter bson.M) (int64, error)
+	// Sources 按文件名分组统计符合 filter 的分块，按文件名排序
+	Sources(ctx context.Context, filter bson.M) ([]Source, error)
+
+	// VectorStore 基于当前集合和向量索引的向量库
+	VectorStore(emb embeddings.Embedder) vectorstores.VectorStore
+	// Collection 同一个数据库中的其他集合，与当前 Store 共用连接，不需要单独 Close；默认使用相同的索引配置
+	Collection(name string, opts ...CollectionOption) Store
+	Close(ctx context.Context) error
+}
+
+var (
+	_ Store = (*MongodbStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+type collectionOptions struct {
+	idx    string
+	fields []Field
+}
+
+type CollectionOption func(*collectionOptions)
+
+// WithCollectionIndex 其他集合使用单独的向量索引，fields 为空时使用默认的向量字段
+func WithCollectionIndex(idx string, fields ...Field) CollectionOption {
+	return func(o *collectionOptions) {
+		o.idx, o.fields = idx, fields
+		if len(fields) < 1 {
+			o.fields = defaultFields()
+		}
+	}
+}
+
+func newCollectionOptions(idx string, fields []Field, opts []CollectionOption) collectionOptions {
+	o := collectionOptions{idx: idx, fields: fields}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// defaultFields 默认的向量索引字段
+func defaultFields() []Field {
+	return []Field{{
+		Type:          FieldTypeVector,
+		Path:          "plot_embedding",
+		NumDimensions: 2048,
+		Similarity:    FieldSimilarityDotProduct,
+	}}
+}
+
+// vectorPath 获取索引字段中的向量字段
+func vectorPath(fields []Field) string {
+	for _, f := range fields {
+		if f.Type == FieldTypeVector {
+			return f.Path
+		}
+	}
+	return "plot_embedding"
+}
+
+// vectorSimilarity 向量字段的相似度计算方式
+func vectorSimilarity(fields []Field) FieldSimilarity {
+	for _, f := range fields {
+		if f.Type == FieldTypeVector {
+			return f.Similarity
+		}
+	}
+	return ""
+}
+
+// SetStore 设置保存分块的集合，集合和索引不存在时自动创建；
+// 之前的集合与 store 不共用连接时切换后关闭，之前添加的知识库和语义缓存会被移除
+func (c *Client) SetStore(ctx context.Context, store Store) error {
+	if err := ensureStore(c.observe(ctx), store); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	closing := c.replaceBackend(store)
+	c.mu.Unlock()
+	return closeStores(ctx, closing)
+}
+
+// replaceBackend 将保存分块的集合替换为 store，需要在持有 c.mu 时调用，返回不再使用、需要关闭的连接。
+// 连接变化时移除旧连接上的知识库和语义缓存，mongodb 向量缓存切换到 store 的连接，store 不是 mongodb 时移除
+func (c *Client) replaceBackend(store Store) []Store {
+	var closing []Store
+	if c.backend != nil && c.backend != store && !sameConn(c.backend, store) {
+		closing = append(closing, c.backend)
+	}
+	if c.conn != nil && !sameConn(c.conn, store) {
+		if !sameConn(c.conn, c.backend) {
+			closing = append(closing, c.conn)
+		}
+		c.conn, c.connURI = nil, ""
+	}
+	c.backend, c.store = store, nil
+	if len(closing) > 0 {
+		m, _ := store.(*MongodbStore)
+		c.bases, c.answers, c.cache = nil, nil, c.cache.reconnect(closing, m)
+	}
+	return closing
+}
+
+// closeStores 关闭 stores，返回所有错误
+func closeStores(ctx context.Context, stores []Store) error {
+	var errs []error
+	for _, s := range stores {
+		errs = append(errs, s.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// sameConn a 和 b 是否共用同一个 mongodb 连接
+func sameConn(a, b Store) bool {
+	ma, ok := a.(*MongodbStore)
+	if !ok {
+		return false
+	}
+	mb, ok := b.(*MongodbStore)
+	return ok && ma.client == mb.client
+}
+
+// getBackend 当前保存分块的集合，未设置时返回 nil
+func (c *Client) getBackend() Store {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.backend
+}
+
+// ensureStore 集合和索引不存在时创建
+func ensureStore(ctx context.Context, store Store) error {
+	if !store.SelectCollection(ctx) {
+		if err := store.CreateCollection(ctx); err != nil {
+			return err
+		}
+	}
+	if ok, _ := store.SelectIndex(ctx, store.Index()); !ok {
+		return store.CreateIndex(ctx, store.Index(), store.Fields())
+	}
+	return nil
+}
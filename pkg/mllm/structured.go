@@ -0,0 +1,370 @@
+package mllm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StructuredPrompt 结构化输出时添加到系统消息中的提示词，%s 为 JSON Schema
+var StructuredPrompt = "只输出一个符合以下 JSON Schema 的 JSON 对象，不要输出 JSON 以外的任何内容：\n%s"
+
+// StructuredRetryPrompt 解析失败时重新请求的提示词，%s 为错误信息
+var StructuredRetryPrompt = "上一次的输出不符合要求：%s\n请修正后重新输出，只输出 JSON。"
+
+// Validator 结构化输出的类型实现该接口时，解析后会调用 Validate 进行业务校验
+type Validator interface {
+	Validate() error
+}
+
+// StructuredError 重试次数用完后仍然解析失败
+type StructuredError struct {
+	Attempts int    // 请求次数
+	Raw      string // 最后一次模型返回的内容
+	Err      error  // 最后一次解析错误
+}
+
+func (e *StructuredError) Error() string {
+	return fmt.Sprintf("结构化输出失败(请求%d次)：%s", e.Attempts, e.Err.Error())
+}
+
+func (e *StructuredError) Unwrap() error {
+	return e.Err
+}
+
+// Structured 要求模型按照 T 的 JSON Schema 输出，并解析为 T。
+// 使用 Ollama 的 json 格式模式，解析或校验失败时携带错误信息重新请求，最多重试 retries 次
+//
+// T 必须是结构体，字段通过 json 标签命名，describe 标签作为字段说明，enum 标签限制取值(逗号分隔)，
+// 没有 omitempty 的字段为必填
+func Structured[T any](ctx context.Context, c *Client, messages []llms.MessageContent, retries int, opts ...llms.CallOption) (*T, error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("结构化输出只支持结构体，不支持 %s", typ)
+	}
+
+	schema := JSONSchema(typ)
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	messages = withSystem(messages, fmt.Sprintf(StructuredPrompt, data))
+	opts = append(opts, llms.WithJSONMode())
+
+	serr := &StructuredError{}
+	for serr.Attempts <= retries {
+		serr.Attempts++
+		resp, err := c.GenerateContent(ctx, messages, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) < 1 {
+			return nil, fmt.Errorf("模型返回空消息")
+		}
+
+		serr.Raw = resp.Choices[0].Content
+		res, err := parseStructured[T](serr.Raw, schema)
+		if err == nil {
+			return res, nil
+		}
+		serr.Err = err
+
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeAI, serr.Raw),
+			llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(StructuredRetryPrompt, err.Error())),
+		)
+	}
+	return nil, serr
+}
+
+// parseStructured 按 schema 校验模型输出后解析为 T
+func parseStructured[T any](raw string, schema map[string]any) (*T, error) {
+	raw = trimCodeFence(raw)
+
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, fmt.Errorf("不是合法的 JSON：%w", err)
+	}
+	if err := validateSchema(schema, v, "$"); err != nil {
+		return nil, err
+	}
+
+	res := new(T)
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(res); err != nil {
+		return nil, err
+	}
+	if val, ok := any(res).(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// trimCodeFence 去掉模型输出中的 ```json 代码块标记
+func trimCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	s = strings.TrimPrefix(s, "```")
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+}
+
+// withSystem 将提示词添加到第一条系统消息中，没有系统消息时新增一条，不修改原消息
+func withSystem(messages []llms.MessageContent, prompt string) []llms.MessageContent {
+	res := make([]llms.MessageContent, 0, len(messages)+1)
+	if len(messages) > 0 && messages[0].Role == llms.ChatMessageTypeSystem {
+		system := partsText(messages[0].Parts) + "\n\n" + prompt
+		res = append(res, llms.TextParts(llms.ChatMessageTypeSystem, system))
+		return append(res, messages[1:]...)
+	}
+	res = append(res, llms.TextParts(llms.ChatMessageTypeSystem, prompt))
+	return append(res, messages...)
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// JSONSchema 根据 Go 类型生成 JSON Schema，字段规则与 encoding/json 一致：
+// 匿名结构体的字段展开到外层，[]byte 为 base64 字符串，指针字段不是必填字段并且可以为 null
+func JSONSchema(typ reflect.Type) map[string]any {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": JSONSchema(typ.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": JSONSchema(typ.Elem())}
+	case reflect.Struct:
+	default:
+		return map[string]any{}
+	}
+
+	props := make(map[string]any)
+	required := make([]string, 0)
+	for _, f := range jsonFields(typ) {
+		prop := JSONSchema(f.Type)
+		if desc := f.Tag.Get("describe"); desc != "" {
+			prop["description"] = desc
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			prop["enum"] = strings.Split(enum, ",")
+		}
+		nullable := f.Type.Kind() == reflect.Pointer
+		if t, ok := prop["type"].(string); ok && nullable {
+			prop["type"] = []string{t, "null"}
+		}
+		props[f.name] = prop
+		if !f.omitEmpty && !nullable {
+			required = append(required, f.name)
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// jsonField 结构体中参与 JSON 编码的字段
+type jsonField struct {
+	reflect.StructField
+	name      string // JSON 中的字段名
+	tagged    bool   // 字段名来自 json 标签
+	omitEmpty bool
+	depth     int // 匿名结构体的嵌套层数
+}
+
+// jsonFields 按 encoding/json 的规则获取结构体的字段：匿名结构体没有指定名称时字段展开到外层，
+// 同名字段中层数最浅的生效，层数相同时只有一个带 json 标签的生效，否则都忽略
+func jsonFields(typ reflect.Type) []jsonField {
+	all := make([]jsonField, 0, typ.NumField())
+	collectFields(typ, 0, map[reflect.Type]bool{typ: true}, &all)
+
+	res := make([]jsonField, 0, len(all))
+	for i, f := range all {
+		dominant := true
+		for j, o := range all {
+			if i == j || o.name != f.name {
+				continue
+			}
+			if o.depth < f.depth || o.depth == f.depth && (o.tagged || !f.tagged) {
+				dominant = false
+				break
+			}
+		}
+		if dominant {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func collectFields(typ reflect.Type, depth int, visited map[reflect.Type]bool, res *[]jsonField) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		// 未导出的匿名结构体中导出的字段仍然会被编码
+		if !f.IsExported() && !(f.Anonymous && ft.Kind() == reflect.Struct) {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" && f.Anonymous && ft.Kind() == reflect.Struct {
+			if !visited[ft] {
+				visited[ft] = true
+				collectFields(ft, depth+1, visited, res)
+				delete(visited, ft)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+
+		field := jsonField{StructField: f, name: name, tagged: name != "", depth: depth}
+		if name == "" {
+			field.name = f.Name
+		}
+		field.omitEmpty = slices.Contains(strings.Split(opts, ","), "omitempty")
+		*res = append(*res, field)
+	}
+}
+
+// validateSchema 校验 JSONSchema 生成的 schema，只支持其中使用到的关键字
+func validateSchema(schema map[string]any, v any, path string) error {
+	typ, _ := schema["type"].(string)
+	if types, ok := schema["type"].([]string); ok { // 可以为 null 的类型
+		if v == nil && slices.Contains(types, "null") {
+			return nil
+		}
+		typ = types[0]
+	}
+	if typ == "" { // 任意类型
+		return nil
+	}
+	if v == nil {
+		return fmt.Errorf("%s 不能为 null", path)
+	}
+
+	switch typ {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s 应为 boolean", path)
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("%s 应为 %s", path, typ)
+		}
+		if typ == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s 应为 integer", path)
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s 应为 string", path)
+		}
+		if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, s) {
+			return fmt.Errorf("%s 只能是 %s 之一", path, strings.Join(enum, "、"))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s 应为 RFC3339 格式的时间", path)
+			}
+		}
+		if schema["contentEncoding"] == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+				return fmt.Errorf("%s 应为 base64 编码的字符串", path)
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s 应为 array", path)
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range arr {
+			if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s 应为 object", path)
+		}
+		return validateObject(schema, obj, path)
+	}
+	return nil
+}
+
+func validateObject(schema map[string]any, obj map[string]any, path string) error {
+	if props, ok := schema["properties"].(map[string]any); ok {
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s 缺少必填字段 %s", path, name)
+			}
+		}
+		for name, val := range obj {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s 不允许出现字段 %s", path, name)
+			}
+			if val == nil && !slices.Contains(required, name) {
+				continue
+			}
+			if err := validateSchema(prop, val, path+"."+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// map 类型
+	if items, ok := schema["additionalProperties"].(map[string]any); ok {
+		for name, val := range obj {
+			if err := validateSchema(items, val, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
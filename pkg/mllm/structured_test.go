@@ -0,0 +1,151 @@
+package mllm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/tmc/langchaingo/llms"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type schemaBase struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type SchemaMeta struct {
+	Title int      `json:"title"` // 外层的 title 生效
+	Tags  []string `json:"tags,omitempty"`
+}
+
+type schemaDoc struct {
+	schemaBase
+	*SchemaMeta
+	Title string  `json:"title"`
+	Data  []byte  `json:"data"`
+	Score *int    `json:"score"`
+	Note  *string `json:"note,omitempty"`
+	Any   any     `json:"any"`
+}
+
+func TestJSONSchemaFields(t *testing.T) {
+	schema := JSONSchema(reflect.TypeFor[schemaDoc]())
+	props := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	// 匿名结构体的字段展开，name 带标签的生效
+	if want := []string{"any", "data", "id", "name", "note", "score", "tags", "title"}; !slices.Equal(names, want) {
+		t.Fatalf("properties = %v, want %v", names, want)
+	}
+	if want := []string{"id", "name", "title", "data", "any"}; !slices.Equal(schema["required"].([]string), want) {
+		t.Fatalf("required = %v, want %v", schema["required"], want)
+	}
+	if title := props["title"].(map[string]any); title["type"] != "string" {
+		t.Fatalf("title = %v", title)
+	}
+	if data := props["data"].(map[string]any); data["type"] != "string" || data["contentEncoding"] != "base64" {
+		t.Fatalf("data = %v", data)
+	}
+	if score := props["score"].(map[string]any); !slices.Equal(score["type"].([]string), []string{"integer", "null"}) {
+		t.Fatalf("score = %v", score)
+	}
+}
+
+func TestParseStructuredMatchesJSON(t *testing.T) {
+	schema := JSONSchema(reflect.TypeFor[schemaDoc]())
+	want := schemaDoc{
+		schemaBase: schemaBase{ID: 1, Name: "a"},
+		SchemaMeta: &SchemaMeta{Tags: []string{"x"}},
+		Title:      "t",
+		Data:       []byte("hello"),
+	}
+	raw, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encoding/json 的输出可以通过校验并解析回原值
+	got, err := parseStructured[schemaDoc](string(raw), schema)
+	if err != nil {
+		t.Fatalf("parseStructured(%s): %v", raw, err)
+	}
+	if got.ID != 1 || got.Name != "a" || got.Title != "t" || !bytes.Equal(got.Data, want.Data) || got.Score != nil || !slices.Equal(got.Tags, want.Tags) {
+		t.Fatalf("got = %+v", got)
+	}
+
+	for _, raw := range []string{
+		`{"id":1,"name":"a","title":"t","data":"aGVsbG8=","any":null}`,
+		`{"id":1,"name":"a","title":"t","data":"aGVsbG8=","score":null,"any":1}`,
+		`{"id":1,"name":"a","title":"t","data":"aGVsbG8=","score":2,"any":"x"}`,
+	} {
+		if _, err := parseStructured[schemaDoc](raw, schema); err != nil {
+			t.Fatalf("parseStructured(%s): %v", raw, err)
+		}
+	}
+	for _, raw := range []string{
+		`{"name":"a","title":"t","data":"aGVsbG8=","any":1}`,                    // 缺少展开的必填字段
+		`{"id":1,"name":"a","title":"t","data":"不是base64","any":1}`,             // data 不是 base64
+		`{"id":1,"name":"a","title":"t","data":[104],"any":1}`,                  // data 不是字符串
+		`{"id":1,"name":"a","title":"t","data":"aGVsbG8=","score":"x","any":1}`, // score 类型错误
+	} {
+		if _, err := parseStructured[schemaDoc](raw, schema); err == nil {
+			t.Fatalf("parseStructured(%s) 应返回错误", raw)
+		}
+	}
+}
+
+type structuredAnswer struct {
+	Text  string `json:"text"`
+	Score int    `json:"score"`
+}
+
+func TestStructuredRetry(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.ReplyText(`不是 JSON`, `{"text":"好","score":1}`)
+
+	msgs := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "评价")}
+	res, err := Structured[structuredAnswer](context.Background(), c, msgs, 1)
+	if err != nil {
+		t.Fatalf("Structured: %v", err)
+	}
+	if res.Text != "好" || res.Score != 1 {
+		t.Fatalf("res = %+v", res)
+	}
+
+	// 第二次请求带上上一次的输出和修正提示
+	reqs := srv.RequestsTo("/api/chat")
+	if len(reqs) != 2 {
+		t.Fatalf("chat requests = %d", len(reqs))
+	}
+	retry := reqs[1].Messages
+	if n := len(retry); n < 2 || retry[n-2].Role != "assistant" || retry[n-2].Content != "不是 JSON" ||
+		!strings.Contains(retry[n-1].Content, "不是合法的 JSON") {
+		t.Fatalf("retry messages = %+v", retry)
+	}
+}
+
+func TestStructuredGiveUp(t *testing.T) {
+	c, srv := newTestClient(t)
+	srv.ReplyText(`不是 JSON`, `{"text":"好"}`)
+
+	msgs := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "评价")}
+	_, err := Structured[structuredAnswer](context.Background(), c, msgs, 1)
+	var serr *StructuredError
+	if !errors.As(err, &serr) {
+		t.Fatalf("err = %v, want StructuredError", err)
+	}
+	// 返回最后一次的输出和校验错误
+	if serr.Attempts != 2 || serr.Raw != `{"text":"好"}` || !strings.Contains(serr.Err.Error(), "score") {
+		t.Fatalf("err = %+v", serr)
+	}
+	if n := len(srv.RequestsTo("/api/chat")); n != 2 {
+		t.Fatalf("chat requests = %d", n)
+	}
+}
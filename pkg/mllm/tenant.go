@@ -0,0 +1,137 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"github.com/tmc/langchaingo/vectorstores"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"maps"
+	"slices"
+)
+
+// TenantKey 写入到分块元数据中的租户，同一个集合中不同租户的分块互相隔离
+var TenantKey = "tenant"
+
+// ErrTenantRequired 设置了 SetTenantRequired 时 ctx 中没有租户
+var ErrTenantRequired = errors.New("未指定租户")
+
+type tenantKey struct{}
+
+// WithTenant 返回带租户的 ctx，Client 使用该 ctx 添加的分块属于该租户，检索、问答、统计和删除只作用于该租户的分块
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext 获取 ctx 中的租户
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+// TenantField 租户的过滤字段，使用租户时需要添加到向量索引中，否则无法在向量检索中过滤
+func TenantField() Field {
+	return Field{Type: FieldTypeFilter, Path: "metadata." + TenantKey}
+}
+
+// SetTenantRequired 设置为 true 时所有访问知识库的操作都必须通过 WithTenant 指定租户，否则返回 ErrTenantRequired
+func (c *Client) SetTenantRequired(required bool) {
+	c.mu.Lock()
+	c.tenantRequired = required
+	c.mu.Unlock()
+}
+
+// tenantFilter ctx 中租户对应的过滤条件，没有租户时只匹配不属于任何租户的分块
+func (c *Client) tenantFilter(ctx context.Context) (bson.M, error) {
+	tenant, ok := TenantFromContext(ctx)
+	if ok {
+		return bson.M{"metadata." + TenantKey: tenant}, nil
+	}
+
+	c.mu.RLock()
+	required := c.tenantRequired
+	c.mu.RUnlock()
+	if required {
+		return nil, ErrTenantRequired
+	}
+	return bson.M{"metadata." + TenantKey: bson.M{"$exists": false}}, nil
+}
+
+// scopeFilter 在 filter 中加上租户条件，filter 中已有租户字段时两个条件同时生效
+func (c *Client) scopeFilter(ctx context.Context, filter bson.M) (bson.M, error) {
+	tf, err := c.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := filter["metadata."+TenantKey]; ok {
+		return bson.M{"$and": bson.A{filter, tf}}, nil
+	}
+	res := maps.Clone(filter)
+	if res == nil {
+		res = make(bson.M, len(tf))
+	}
+	maps.Copy(res, tf)
+	return res, nil
+}
+
+// hasTenantField store 的向量索引中是否有租户过滤字段
+func hasTenantField(store Store) bool {
+	path := TenantField().Path
+	return slices.ContainsFunc(store.Fields(), func(f Field) bool {
+		return f.Type == FieldTypeFilter && f.Path == path
+	})
+}
+
+// scopeSearch 在检索 store 的选项中加上租户条件，与已有的 vectorstores.WithFilters 同时生效；
+// 没有租户且索引中没有租户字段时不过滤，AddDocuments 不会向这样的集合写入属于租户的分块；
+// store 为 nil 表示通过 SetVectorStore 设置的向量库，总是过滤
+func (c *Client) scopeSearch(ctx context.Context, opts []vectorstores.Option, store Store) ([]vectorstores.Option, error) {
+	tf, err := c.tenantFilter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := TenantFromContext(ctx); !ok && store != nil && !hasTenantField(store) {
+		return opts, nil
+	}
+
+	filter := any(tf)
+	if o := vectorstoreOptions(opts); o.Filters != nil {
+		filter = bson.M{"$and": bson.A{o.Filters, tf}}
+	}
+	return append(opts[:len(opts):len(opts)], vectorstores.WithFilters(filter)), nil
+}
+
+// DeleteTenant 删除租户在当前集合和所有知识库中的分块以及缓存的回答，返回删除的分块数量
+func (c *Client) DeleteTenant(ctx context.Context, tenant string) (int64, error) {
+	if tenant == "" {
+		return 0, errors.New("租户不能为空")
+	}
+	filter := bson.M{"metadata." + TenantKey: tenant}
+
+	c.writeMu.RLock()
+	defer c.writeMu.RUnlock()
+	c.mu.RLock()
+	stores := slices.Collect(maps.Values(c.bases))
+	if c.backend != nil {
+		stores = append(stores, c.backend)
+	}
+	a := c.answers
+	c.mu.RUnlock()
+	if len(stores) < 1 {
+		return 0, errors.New("未设置mongodb store")
+	}
+
+	var total int64
+	for _, store := range stores {
+		n, err := store.DeleteMany(ctx, filter)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	if a != nil {
+		if _, err := a.store.DeleteMany(ctx, filter); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,155 @@
+package mllm
+
+import (
+	"context"
+	"errors"
+	"github.com/tmc/langchaingo/schema"
+	"study_langchain/pkg/mllm/ollamatest"
+	"testing"
+)
+
+// useTenantStore 使用带租户过滤字段的内存集合
+func useTenantStore(t *testing.T, c *Client, srv *ollamatest.Server) *MemoryStore {
+	t.Helper()
+	field := Field{Type: FieldTypeVector, Path: "embedding", NumDimensions: srv.Dimensions(), Similarity: FieldSimilarityCosine}
+	store := NewMemoryStore("vector", "vector_index", WithMemoryFields(field, TenantField()))
+	if err := c.SetStore(context.Background(), store); err != nil {
+		t.Fatalf("SetStore: %v", err)
+	}
+	return store
+}
+
+func TestTenantIsolation(t *testing.T) {
+	c, srv := newTestClient(t)
+	store := useTenantStore(t, c, srv)
+	a := WithTenant(context.Background(), "a")
+	b := WithTenant(context.Background(), "b")
+
+	// 不同租户添加同一个文件时互不影响
+	filename := writeFile(t, "doc.txt", "study_langchain 使用 MongoDB Atlas 保存向量。")
+	for _, ctx := range []context.Context{a, b, a} {
+		if _, err := c.AddDocuments(ctx, filename); err != nil {
+			t.Fatalf("AddDocuments: %v", err)
+		}
+	}
+	other := writeFile(t, "other.txt", "租户 b 的另一个文件。")
+	if _, err := c.AddDocuments(b, other); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	if n := countDocs(t, store); n != 3 {
+		t.Fatalf("chunks = %d", n)
+	}
+
+	docs, err := c.Search(a, "向量", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Metadata[TenantKey] != "a" {
+		t.Fatalf("docs = %+v", docs)
+	}
+
+	srv.ReplyText("MongoDB Atlas")
+	res, err := c.Chain(b, "向量保存在哪里？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	for _, doc := range res["source_documents"].([]schema.Document) {
+		if doc.Metadata[TenantKey] != "b" {
+			t.Fatalf("source_documents = %+v", res["source_documents"])
+		}
+	}
+
+	statsA, err := c.Stats(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsB, err := c.Stats(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statsA.Sources != 1 || statsB.Sources != 2 {
+		t.Fatalf("stats a = %+v, b = %+v", statsA, statsB)
+	}
+
+	// 删除只作用于当前租户
+	if n, err := c.DeleteBySource(a, other); err != nil || n != 0 {
+		t.Fatalf("DeleteBySource = %d, %v", n, err)
+	}
+	if n, err := c.DeleteTenant(context.Background(), "b"); err != nil || n != 2 {
+		t.Fatalf("DeleteTenant = %d, %v", n, err)
+	}
+	if n := countDocs(t, store); n != 1 {
+		t.Fatalf("chunks = %d", n)
+	}
+}
+
+func TestTenantRequired(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useTenantStore(t, c, srv)
+	c.SetTenantRequired(true)
+
+	filename := writeFile(t, "doc.txt", "内容")
+	if _, err := c.AddDocuments(ctx, filename); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("AddDocuments err = %v", err)
+	}
+	if _, err := c.Search(ctx, "内容", 4); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("Search err = %v", err)
+	}
+	if _, err := c.Chain(ctx, "内容"); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("Chain err = %v", err)
+	}
+	if _, err := c.Stats(ctx); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("Stats err = %v", err)
+	}
+	if _, err := c.DeleteBySource(ctx, filename); !errors.Is(err, ErrTenantRequired) {
+		t.Fatalf("DeleteBySource err = %v", err)
+	}
+	if _, err := c.AddDocuments(WithTenant(ctx, "a"), filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+}
+
+func TestTenantUnscoped(t *testing.T) {
+	ctx := context.Background()
+	c, srv := newTestClient(t)
+	useTenantStore(t, c, srv)
+	if err := c.SetAnswerCache(ctx, "answers"); err != nil {
+		t.Fatalf("SetAnswerCache: %v", err)
+	}
+
+	a := WithTenant(ctx, "a")
+	if _, err := c.AddDocuments(a, writeFile(t, "doc.txt", "租户 a 的向量保存在 MongoDB Atlas。")); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+	srv.ReplyText("MongoDB Atlas")
+	if _, err := c.Chain(a, "向量保存在哪里？"); err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+
+	// 没有租户时看不到租户的分块和缓存的回答
+	docs, err := c.Search(ctx, "向量", 10)
+	if err != nil || len(docs) != 0 {
+		t.Fatalf("Search = %+v, %v", docs, err)
+	}
+	srv.ReplyText("不知道")
+	res, err := c.Chain(ctx, "向量保存在哪里？")
+	if err != nil {
+		t.Fatalf("Chain: %v", err)
+	}
+	if res["cached"] == true || res["text"] != "不知道" {
+		t.Fatalf("res = %+v", res)
+	}
+}
+
+func TestTenantWithoutField(t *testing.T) {
+	c, srv := newTestClient(t)
+	useMemoryStore(t, c, srv)
+	filename := writeFile(t, "doc.txt", "内容")
+	if _, err := c.AddDocuments(WithTenant(context.Background(), "a"), filename); err == nil {
+		t.Fatal("索引中没有租户字段时应返回错误")
+	}
+	if _, err := c.AddDocuments(context.Background(), filename); err != nil {
+		t.Fatalf("AddDocuments: %v", err)
+	}
+}
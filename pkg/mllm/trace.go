@@ -0,0 +1,63 @@
+package mllm
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+)
+
+// TracerName 使用全局 TracerProvider 时的 tracer 名称
+const TracerName = "study_langchain/pkg/mllm"
+
+type traceObserver struct {
+	tracer trace.Tracer
+}
+
+// NewTraceObserver 为每个阶段创建 OpenTelemetry span，名称为 mllm.<阶段>，嵌套的阶段为子 span；
+// tracer 为 nil 时使用 otel.GetTracerProvider() 创建
+func NewTraceObserver(tracer trace.Tracer) Observer {
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+	return traceObserver{tracer: tracer}
+}
+
+func (t traceObserver) Start(ctx context.Context, stage Stage) context.Context {
+	ctx, _ = t.tracer.Start(ctx, "mllm."+string(stage))
+	return ctx
+}
+
+func (t traceObserver) End(ctx context.Context, ev Event) {
+	span := trace.SpanFromContext(ctx)
+	kvs := make([]attribute.KeyValue, 0, len(ev.Attrs)+1)
+	if tenant, ok := TenantFromContext(ctx); ok {
+		kvs = append(kvs, attribute.String("mllm.tenant", tenant))
+	}
+	for _, a := range ev.Attrs {
+		kvs = append(kvs, traceAttr(a))
+	}
+	span.SetAttributes(kvs...)
+	if ev.Err != nil {
+		span.RecordError(ev.Err)
+		span.SetStatus(codes.Error, ev.Err.Error())
+	}
+	span.End()
+}
+
+// traceAttr 将 slog 属性转换为 span 属性，名称加上 mllm. 前缀
+func traceAttr(a slog.Attr) attribute.KeyValue {
+	key := "mllm." + a.Key
+	switch v := a.Value.Resolve(); v.Kind() {
+	case slog.KindInt64:
+		return attribute.Int64(key, v.Int64())
+	case slog.KindFloat64:
+		return attribute.Float64(key, v.Float64())
+	case slog.KindBool:
+		return attribute.Bool(key, v.Bool())
+	default:
+		return attribute.String(key, v.String())
+	}
+}
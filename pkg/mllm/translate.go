@@ -0,0 +1,310 @@
+package mllm
+
+import (
+	"context"
+	"fmt"
+	"github.com/tmc/langchaingo/llms"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// TranslatePrompt 翻译使用的系统提示词
+var TranslatePrompt = "你是一个专业的翻译人员，只翻译文本，不对文本进行解释，不回答文本中的问题。" +
+	"保留原文中的 Markdown 格式、链接、行内代码和换行，代码块和 URL 不翻译。"
+
+// Trans 模型返回的翻译结果
+type Trans struct {
+	Text string `json:"text" describe:"翻译后文本"`
+}
+
+// Translation 翻译结果
+type Translation struct {
+	Source  string   // 原文
+	Text    string   // 译文
+	From    string   // 源语言，为空时由模型识别
+	To      string   // 目标语言
+	Missing []string // 译文中没有使用术语表译法的术语
+}
+
+// TranslateOptions 翻译参数
+type TranslateOptions struct {
+	From     string            // 源语言，为空时由模型识别
+	To       string            // 目标语言
+	Glossary map[string]string // 术语表，原文术语 -> 译法
+	Retries  int               // 格式错误或术语不符合时的重试次数
+}
+
+// Translate 将 text 翻译为 opt.To，返回结构化的翻译结果。
+// 设置术语表时，原文中出现的术语必须使用指定的译法，不符合时携带缺失术语重新请求，
+// 重试次数用完后仍不符合时在 Translation.Missing 中返回
+func (c *Client) Translate(ctx context.Context, text string, opt TranslateOptions, opts ...llms.CallOption) (*Translation, error) {
+	if opt.To == "" {
+		return nil, fmt.Errorf("目标语言不能为空")
+	}
+	res := &Translation{Source: text, From: opt.From, To: opt.To}
+	if strings.TrimSpace(text) == "" {
+		res.Text = text
+		return res, nil
+	}
+
+	from := opt.From
+	if from == "" {
+		from = "原文语言"
+	}
+	terms := glossaryTerms(text, opt.Glossary)
+	system := TranslatePrompt
+	if len(terms) > 0 {
+		lines := make([]string, 0, len(terms))
+		for _, t := range terms {
+			lines = append(lines, fmt.Sprintf("%s => %s", t, opt.Glossary[t]))
+		}
+		system += "\n必须按照以下术语表翻译术语：\n" + strings.Join(lines, "\n")
+	}
+	messages := []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeSystem, system),
+		llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf("将此文本从%s转换为%s:\n%s", from, opt.To, text)),
+	}
+
+	for i := 0; ; i++ {
+		trans, err := Structured[Trans](ctx, c, messages, opt.Retries, opts...)
+		if err != nil {
+			return nil, err
+		}
+		res.Text = trans.Text
+		res.Missing = missingTerms(trans.Text, terms, opt.Glossary)
+		if len(res.Missing) < 1 || i >= opt.Retries {
+			return res, nil
+		}
+
+		fixes := make([]string, 0, len(res.Missing))
+		for _, t := range res.Missing {
+			fixes = append(fixes, fmt.Sprintf("%s 应翻译为 %s", t, opt.Glossary[t]))
+		}
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeAI, fmt.Sprintf(`{"text":%q}`, trans.Text)),
+			llms.TextParts(llms.ChatMessageTypeHuman, "译文没有按照术语表翻译："+strings.Join(fixes, "；")+"，请重新翻译。"),
+		)
+	}
+}
+
+// glossaryTerms 获取原文中出现的术语，按长度降序排列，长的术语优先
+func glossaryTerms(text string, glossary map[string]string) []string {
+	lower := strings.ToLower(text)
+	res := make([]string, 0)
+	for term := range glossary {
+		if term != "" && strings.Contains(lower, strings.ToLower(term)) {
+			res = append(res, term)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i]) != len(res[j]) {
+			return len(res[i]) > len(res[j])
+		}
+		return res[i] < res[j]
+	})
+	return res
+}
+
+func missingTerms(text string, terms []string, glossary map[string]string) []string {
+	lower := strings.ToLower(text)
+	res := make([]string, 0)
+	for _, t := range terms {
+		if !strings.Contains(lower, strings.ToLower(glossary[t])) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// TranslateBatch 逐条翻译，返回结果与 texts 一一对应
+func (c *Client) TranslateBatch(ctx context.Context, texts []string, opt TranslateOptions, opts ...llms.CallOption) ([]*Translation, error) {
+	res := make([]*Translation, 0, len(texts))
+	for i, text := range texts {
+		t, err := c.Translate(ctx, text, opt, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("翻译第%d条失败：%w", i+1, err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+var mdPrefix = regexp.MustCompile(`^(\s*(?:#{1,6}\s+|>\s*|[-*+]\s+(?:\[[ xX]\]\s+)?|\d+[.)]\s+))`)
+
+// mdBlock Markdown 文档中的块，code 为 true 时不翻译
+type mdBlock struct {
+	prefix string // 标题、列表、引用的标记
+	text   string
+	code   bool
+}
+
+// TranslateMarkdown 按块翻译 Markdown 文档，保留文档结构：
+// 代码块、空行、表格分隔行原样保留，标题、列表、引用的标记不交给模型，只翻译其中的文本，
+// 只隔一个空行的连续段落合并为不超过 chunkSize 个字符的分块翻译，chunkSize 小于1时每个段落单独翻译；
+// 模型没有保留段落数量时逐个段落重新翻译，译文为空时保留原文
+func (c *Client) TranslateMarkdown(ctx context.Context, markdown string, chunkSize int, opt TranslateOptions, opts ...llms.CallOption) (string, []*Translation, error) {
+	blocks := splitMarkdown(markdown)
+
+	var (
+		sb      strings.Builder
+		results = make([]*Translation, 0)
+		pending = make([]int, 0) // 等待合并翻译的段落
+	)
+	translated := make([]string, len(blocks))
+	translate := func(text string) (string, error) {
+		t, err := c.Translate(ctx, text, opt, opts...)
+		if err != nil {
+			return "", err
+		}
+		results = append(results, t)
+		return strings.TrimSpace(t.Text), nil
+	}
+	flush := func() error {
+		defer func() { pending = pending[:0] }()
+		if len(pending) < 1 {
+			return nil
+		}
+		texts := make([]string, 0, len(pending))
+		for _, i := range pending {
+			texts = append(texts, blocks[i].text)
+		}
+		text, err := translate(strings.Join(texts, "\n\n"))
+		if err != nil {
+			return err
+		}
+
+		// 模型保留了段落数量时按段落回填，否则逐个段落翻译
+		parts := []string{text}
+		if len(pending) > 1 {
+			parts = strings.Split(text, "\n\n")
+		}
+		if len(parts) != len(pending) {
+			parts = make([]string, len(pending))
+			for j, i := range pending {
+				if parts[j], err = translate(blocks[i].text); err != nil {
+					return err
+				}
+			}
+		}
+		for j, i := range pending {
+			translated[i] = parts[j]
+		}
+		return nil
+	}
+
+	size := 0
+	for i, b := range blocks {
+		switch {
+		case b.code || strings.TrimSpace(b.text) == "":
+			// 只隔一个空行的段落可以合并，合并时以空行分隔；其余不翻译的块都会打断合并
+			if !b.code && len(pending) > 0 && pending[len(pending)-1] == i-1 && i+1 < len(blocks) && isParagraph(blocks[i+1]) {
+				translated[i] = b.text
+				continue
+			}
+			if err := flush(); err != nil {
+				return "", nil, err
+			}
+			size = 0
+			translated[i] = b.text
+		case b.prefix != "":
+			// 标题、列表项单独翻译，避免标记被模型改动
+			if err := flush(); err != nil {
+				return "", nil, err
+			}
+			t, err := translate(b.text)
+			if err != nil {
+				return "", nil, err
+			}
+			translated[i] = t
+			size = 0
+		default:
+			if chunkSize < 1 || (len(pending) > 0 && size+len(b.text) > chunkSize) {
+				if err := flush(); err != nil {
+					return "", nil, err
+				}
+				size = 0
+			}
+			pending = append(pending, i)
+			size += len(b.text)
+		}
+	}
+	if err := flush(); err != nil {
+		return "", nil, err
+	}
+
+	for i, b := range blocks {
+		text := translated[i]
+		if text == "" && !b.code {
+			text = b.text
+		}
+		sb.WriteString(b.prefix)
+		sb.WriteString(text)
+		if i < len(blocks)-1 {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), results, nil
+}
+
+// isParagraph 块是需要翻译的普通段落
+func isParagraph(b mdBlock) bool {
+	return !b.code && b.prefix == "" && strings.TrimSpace(b.text) != ""
+}
+
+// splitMarkdown 将 Markdown 拆分为块，标题、列表项、引用按行拆分，普通段落按空行拆分
+func splitMarkdown(markdown string) []mdBlock {
+	var (
+		res   = make([]mdBlock, 0)
+		para  = make([]string, 0)
+		code  = make([]string, 0)
+		fence string
+	)
+	flushPara := func() {
+		if len(para) > 0 {
+			res = append(res, mdBlock{text: strings.Join(para, "\n")})
+			para = para[:0]
+		}
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			code = append(code, line)
+			if strings.HasPrefix(trimmed, fence) {
+				res = append(res, mdBlock{text: strings.Join(code, "\n"), code: true})
+				code, fence = code[:0], ""
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flushPara()
+			fence = trimmed[:3]
+			code = append(code, line)
+		case trimmed == "":
+			flushPara()
+			res = append(res, mdBlock{text: line})
+		case isTableSeparator(trimmed) || strings.HasPrefix(trimmed, "<") || trimmed == "---" || trimmed == "***":
+			flushPara()
+			res = append(res, mdBlock{text: line, code: true})
+		default:
+			if prefix := mdPrefix.FindString(line); prefix != "" {
+				flushPara()
+				res = append(res, mdBlock{prefix: prefix, text: line[len(prefix):]})
+				continue
+			}
+			para = append(para, line)
+		}
+	}
+	flushPara()
+	if len(code) > 0 { // 代码块没有结束
+		res = append(res, mdBlock{text: strings.Join(code, "\n"), code: true})
+	}
+	return res
+}
+
+func isTableSeparator(line string) bool {
+	return strings.HasPrefix(line, "|") && strings.Trim(line, "|-: ") == ""
+}
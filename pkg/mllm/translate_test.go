@@ -0,0 +1,32 @@
+package mllm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTranslateMarkdown(t *testing.T) {
+	c, srv := newTestClient(t)
+	markdown := "# 标题\n第一段\n\n第二段\n\n```go\nx := 1\n```\n第三段\n|---|---|\n第四段\n- 列表"
+	srv.ReplyText(
+		`{"text":""}`,     // 标题的译文为空时保留原文
+		`{"text":"P1P2"}`, // 合并翻译时段落数量不一致
+		`{"text":"P1"}`,
+		`{"text":"P2"}`,
+		`{"text":"P3"}`, // 表格分隔行前后的段落分开翻译
+		`{"text":"P4"}`,
+		`{"text":"Item"}`,
+	)
+
+	text, results, err := c.TranslateMarkdown(context.Background(), markdown, 100, TranslateOptions{To: "英文"})
+	if err != nil {
+		t.Fatalf("TranslateMarkdown: %v", err)
+	}
+	want := "# 标题\nP1\n\nP2\n\n```go\nx := 1\n```\nP3\n|---|---|\nP4\n- Item"
+	if text != want {
+		t.Fatalf("text = %q, want %q", text, want)
+	}
+	if len(results) != 7 || len(srv.RequestsTo("/api/chat")) != 7 {
+		t.Fatalf("results = %d, requests = %d", len(results), len(srv.RequestsTo("/api/chat")))
+	}
+}
@@ -22,3 +22,9 @@ go.format:
 	$(GO) mod edit -fmt
 	$(GIT) add -A .
 
+
+# 单元测试，不依赖 ollama 和 mongodb
+.PHONY: go.test
+go.test:
+	@echo "===========> Run unit test"
+	$(GO) test -count=1 ./...